@@ -0,0 +1,340 @@
+package hll
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// The binary format (from MarshalBinary) is a header describing the sketch, followed by its registers:
+//
+//	magic           4 bytes   binaryMagic
+//	format version  1 byte    binaryFormatVersion
+//	precision       1 byte
+//	encoding        1 byte    the RegisterEncoding used in memory
+//	flags           1 byte    binaryFlagSparse if the sketch was sparse
+//	payload         1 byte    how registers are stored (binaryPayloadPacked or binaryPayloadRunLength)
+//	seed            uvarint   the seed's fingerprint (see seedFingerprint), 0 if unseeded
+//	version         uvarint length, then bytes
+//	hasher id       uvarint length, then bytes
+//	registers       as payload
+//
+// Packed registers are 6 bits each (as Encoding6Bit), which is always enough. Run-length registers are pairs of
+// (uvarint run length, register value) covering every register in order, which is much smaller for sketches
+// with few registers touched (or equal).
+const (
+	binaryMagic         = "HLLB"
+	binaryFormatVersion = 1
+	binaryHeaderSize    = len(binaryMagic) + 5
+
+	binaryFlagSparse = 1 << 0
+
+	binaryPayloadPacked    = 0
+	binaryPayloadRunLength = 1
+)
+
+// MarshalBinary returns s in a compact binary format, which can be read back by UnmarshalBinary. Like
+// ProtoSerialize, this carries everything needed to merge and insert into s (but not its biases or estimator).
+// Dense registers take 6 bits each (12KB at DefaultPrecision), or less when run-length encoded.
+//
+// Sketches from NewSketch (and friends) implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, so
+// can be used with gob directly (register them via gob.Register(NewSketch()) for Sketch fields). These aren't
+// part of the Sketch interface, since gob would then expect Sketch fields to unmarshal themselves.
+func (s *sketch) MarshalBinary() ([]byte, error) {
+	registers := s.getRegisters()
+	packedSize := registerArraySize(Encoding6Bit, len(registers))
+
+	payload := byte(binaryPayloadPacked)
+
+	if runsSize(registers, packedSize) < packedSize {
+		payload = binaryPayloadRunLength
+	}
+
+	var flags byte
+
+	if s.sparse != nil {
+		flags |= binaryFlagSparse
+	}
+
+	bs := make([]byte, 0, binaryHeaderSize+3*binary.MaxVarintLen64+len(s.version)+len(s.hasher.ID())+packedSize)
+
+	bs = append(bs, binaryMagic...)
+	bs = append(bs, binaryFormatVersion, s.precision, byte(s.encoding), flags, payload)
+	bs = appendUvarint(bs, s.seedFingerprint)
+	bs = appendBinaryString(bs, s.version)
+	bs = appendBinaryString(bs, s.hasher.ID())
+
+	if payload == binaryPayloadRunLength {
+		return appendRuns(bs, registers), nil
+	}
+
+	packed := newPackedRegisters(len(registers))
+
+	for i, r := range registers {
+		if r > 0 {
+			packed.set(uint64(i), r)
+		}
+	}
+
+	return append(bs, packed.bytes...), nil
+}
+
+// appendRuns appends registers to bs as (uvarint run length, value) pairs.
+func appendRuns(bs []byte, registers []uint8) []byte {
+	forEachRun(registers, func(run int, value uint8) bool {
+		bs = appendUvarint(bs, uint64(run))
+		bs = append(bs, value)
+
+		return true
+	})
+
+	return bs
+}
+
+// runsSize returns the size of registers as runs (from appendRuns), or any size of at least limit once it's
+// clear they won't fit in less. (Most sketches with many registers touched stop after a few hundred runs).
+func runsSize(registers []uint8, limit int) int {
+	size := 0
+
+	forEachRun(registers, func(run int, _ uint8) bool {
+		size += uvarintSize(uint64(run)) + 1
+		return size < limit
+	})
+
+	return size
+}
+
+// forEachRun calls fn with each run of equal registers, in order, until fn returns false.
+func forEachRun(registers []uint8, fn func(run int, value uint8) bool) {
+	for i := 0; i < len(registers); {
+		run := 1
+
+		for i+run < len(registers) && registers[i+run] == registers[i] {
+			run += 1
+		}
+
+		if !fn(run, registers[i]) {
+			return
+		}
+
+		i += run
+	}
+}
+
+// uvarintSize returns the number of bytes v takes as a uvarint.
+func uvarintSize(v uint64) int {
+	return (bits.Len64(v|1) + 6) / 7
+}
+
+func appendBinaryString(bs []byte, str string) []byte {
+	bs = appendUvarint(bs, uint64(len(str)))
+	return append(bs, str...)
+}
+
+// UnmarshalBinary replaces s with the sketch in data (from MarshalBinary). Biases, estimators and seeds aren't
+// serialized, so those of s are kept, and data must have been created with the seed of s (so a zero value s, as
+// created by gob, can only unmarshal unseeded sketches). On error, s is left unchanged.
+func (s *sketch) UnmarshalBinary(data []byte) error {
+	decoded, err := unmarshalBinary(data, s.seed)
+
+	if err != nil {
+		return err
+	}
+
+	// (A zero value sketch, as created by gob, has no configuration to keep).
+	if s.biasSet != nil {
+		decoded.biasSet = s.biasSet
+	}
+
+	decoded.estimator = s.estimator
+
+	*s = *decoded
+
+	return nil
+}
+
+// UnmarshalBinary returns the Sketch in data (from MarshalBinary). Like FromProtoSketch, data is validated as
+// it's read, so this is safe to use on untrusted input. An error is returned if data is malformed
+// (ErrorMalformedSketch), isn't a valid precision (ErrorMalformedPrecision), any register is out of range for it
+// (ErrorMalformedRegister), its format or sketch version is unsupported (ErrorUnsupportedVersion), or its Hasher
+// isn't registered (ErrorUnknownHasher), or it's seeded (ErrorMismatchedSeed, see UnmarshalBinaryWithSeed).
+func UnmarshalBinary(data []byte) (Sketch, error) {
+	return unmarshalBinary(data, 0)
+}
+
+// UnmarshalBinaryWithSeed returns the Sketch in data (from MarshalBinary), as UnmarshalBinary, which was created
+// with seed (see SketchOptions.Seed). ErrorMismatchedSeed is returned if it wasn't.
+func UnmarshalBinaryWithSeed(data []byte, seed uint64) (Sketch, error) {
+	return unmarshalBinary(data, seed)
+}
+
+func unmarshalBinary(data []byte, seed uint64) (*sketch, error) {
+	if len(data) < binaryHeaderSize || string(data[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("cannot unmarshal sketch without binary header: %w", ErrorMalformedSketch)
+	}
+
+	header := data[len(binaryMagic):binaryHeaderSize]
+	formatVersion, precision, encoding, flags, payload := header[0], header[1], RegisterEncoding(header[2]), header[3], header[4]
+
+	if formatVersion != binaryFormatVersion {
+		return nil, fmt.Errorf("cannot unmarshal sketch with format version %d (expected: %d): %w", formatVersion, binaryFormatVersion, ErrorUnsupportedVersion)
+	}
+
+	if !validPrecision(precision) {
+		return nil, ErrorMalformedPrecision
+	}
+
+	if !encoding.valid() || flags&^binaryFlagSparse != 0 {
+		return nil, fmt.Errorf("cannot unmarshal sketch with encoding %v (flags: %#x): %w", encoding, flags, ErrorMalformedSketch)
+	}
+
+	data = data[binaryHeaderSize:]
+
+	fingerprint, n := binary.Uvarint(data)
+
+	if n <= 0 {
+		return nil, fmt.Errorf("cannot unmarshal sketch with malformed seed fingerprint: %w", ErrorMalformedSketch)
+	}
+
+	data = data[n:]
+
+	version, data, err := consumeBinaryString(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hasherID, data, err := consumeBinaryString(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if version != currentVersion {
+		return nil, fmt.Errorf("cannot unmarshal sketch with version %q (expected: %q): %w", version, currentVersion, ErrorUnsupportedVersion)
+	}
+
+	hasher, exist := hasherStore[hasherID]
+
+	if !exist {
+		return nil, fmt.Errorf("requested hasher %s was not found - it may not have been registered: %w", hasherID, ErrorUnknownHasher)
+	}
+
+	if _, ok := hasher.(SeededHasher); fingerprint != 0 && !ok {
+		return nil, fmt.Errorf("cannot unmarshal seeded sketch (hasher %s does not support seeds): %w", hasherID, ErrorMalformedSketch)
+	}
+
+	s := createSketchWithPrecision(precision)
+	s.version = version
+	s.hasher = hasher
+	s.seedFingerprint = fingerprint
+	s.encoding = encoding
+
+	if err := s.setDeserializedSeed(seed); err != nil {
+		return nil, err
+	}
+
+	registers := make(byteRegisters, s.registerCount())
+
+	switch payload {
+	case binaryPayloadPacked:
+		err = consumePacked(data, registers)
+	case binaryPayloadRunLength:
+		err = consumeRuns(data, registers)
+	default:
+		err = fmt.Errorf("cannot unmarshal sketch with unknown payload %d: %w", payload, ErrorMalformedSketch)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	largest := maxRankAt(precision)
+
+	for i, r := range registers {
+		if r > largest {
+			return nil, fmt.Errorf("cannot unmarshal sketch with register %d of %d (precision %d allows at most %d): %w", i, r, precision, largest, ErrorMalformedRegister)
+		}
+	}
+
+	switch {
+	case flags&binaryFlagSparse != 0:
+		s.registers = nil
+		s.sparse = newSparseRegisters()
+
+		for i, r := range registers {
+			if r > 0 {
+				s.sparse.insert(uint64(i), r)
+			}
+		}
+
+		s.sparse.flush()
+
+		if s.sparse.size() > s.maxSparseSize() {
+			s.toDense()
+		}
+	case encoding == Encoding8Bit:
+		s.registers = registers
+	default:
+		s.registers = newRegisterArray(encoding, s.registerCount())
+		mergeBytes(s.registers, registers)
+	}
+
+	return s, nil
+}
+
+// consumeBinaryString returns the (uvarint length prefixed) string at the start of data, and the rest of data.
+func consumeBinaryString(data []byte) (string, []byte, error) {
+	length, n := binary.Uvarint(data)
+
+	if n <= 0 || length > uint64(len(data)-n) {
+		return "", nil, fmt.Errorf("cannot unmarshal sketch with malformed string: %w", ErrorMalformedSketch)
+	}
+
+	data = data[n:]
+
+	return string(data[:length]), data[length:], nil
+}
+
+// consumePacked reads 6 bit packed registers from data (which must be exactly their size) into registers.
+func consumePacked(data []byte, registers []uint8) error {
+	if len(data) != registerArraySize(Encoding6Bit, len(registers)) {
+		return fmt.Errorf("cannot unmarshal sketch with %d bytes of packed registers (expected: %d): %w", len(data), registerArraySize(Encoding6Bit, len(registers)), ErrorMalformedSketch)
+	}
+
+	packed := &packedRegisters{bytes: data, n: len(registers)}
+
+	for i := range registers {
+		registers[i] = packed.get(uint64(i))
+	}
+
+	return nil
+}
+
+// consumeRuns reads run-length encoded registers from data (which must cover exactly every register) into
+// registers.
+func consumeRuns(data []byte, registers []uint8) error {
+	i := 0
+
+	for len(data) > 0 {
+		run, n := binary.Uvarint(data)
+
+		if n <= 0 || n >= len(data) || run == 0 || run > uint64(len(registers)-i) {
+			return fmt.Errorf("cannot unmarshal sketch with malformed run at register %d: %w", i, ErrorMalformedSketch)
+		}
+
+		value := data[n]
+
+		for end := i + int(run); i < end; i++ {
+			registers[i] = value
+		}
+
+		data = data[n+1:]
+	}
+
+	if i != len(registers) {
+		return fmt.Errorf("cannot unmarshal sketch with runs covering %d of %d registers: %w", i, len(registers), ErrorMalformedSketch)
+	}
+
+	return nil
+}
@@ -0,0 +1,318 @@
+package hll
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSketch_MarshalBinary(t *testing.T) {
+	for _, encoding := range testEncodings {
+		for _, sparse := range []bool{false, true} {
+			for _, n := range []int{0, 100, 100_000} {
+				options := DefaultSketchOptions()
+				options.Precision = 12
+				options.Encoding = encoding
+				options.Sparse = sparse
+				options.Seed = 1
+
+				s, err := NewSketchWithOptions(options)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for i := 0; i < n; i++ {
+					s.InsertUint64(uint64(i))
+				}
+
+				bs, err := s.(*sketch).MarshalBinary()
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := UnmarshalBinaryWithSeed(bs, 1)
+
+				if err != nil {
+					t.Fatalf("unmarshal binary (%v, sparse: %v, %d) - unexpected error: %v", encoding, sparse, n, err)
+				}
+
+				d := decoded.(*sketch)
+				original := s.(*sketch)
+
+				if d.precision != 12 || d.seedFingerprint != seedFingerprint(1) || d.encoding != encoding || (d.sparse != nil) != (original.sparse != nil) {
+					t.Logf("unmarshal binary (%v, sparse: %v, %d) - expected precision: %d, seed fingerprint: %#x, encoding: %v (sparse: %v), got: %d, seed fingerprint: %#x, encoding: %v (sparse: %v)", encoding, sparse, n, 12, seedFingerprint(1), encoding, original.sparse != nil, d.precision, d.seedFingerprint, d.encoding, d.sparse != nil)
+					t.Fail()
+				}
+
+				registers := decoded.getRegisters()
+
+				if size := runsSize(registers, math.MaxInt); size != len(appendRuns(nil, registers)) {
+					t.Fatalf("runs size (%v, sparse: %v, %d) - expected: %d, got: %d", encoding, sparse, n, len(appendRuns(nil, registers)), size)
+				}
+
+				for i, r := range s.getRegisters() {
+					if registers[i] != r {
+						t.Fatalf("unmarshal binary (%v, sparse: %v, %d) - register %d expected: %d, got: %d", encoding, sparse, n, i, r, registers[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSketch_MarshalBinarySize(t *testing.T) {
+	s := createSketch()
+	bs, _ := s.MarshalBinary()
+
+	// Untouched registers are a single run.
+	if len(bs) > 32 {
+		t.Logf("marshal binary - expected an empty sketch to be at most %d bytes, got: %d", 32, len(bs))
+		t.Fail()
+	}
+
+	for i := 0; i < 100_000; i++ {
+		s.InsertUint64(uint64(i))
+	}
+
+	bs, _ = s.MarshalBinary()
+	protoBs, _ := s.ProtoSerialize()
+
+	// Every register is touched, so they're packed into 6 bits each.
+	if max := registerArraySize(Encoding6Bit, s.registerCount()) + 32; len(bs) > max || len(bs) >= len(protoBs) {
+		t.Logf("marshal binary - expected a full sketch to be at most %d bytes (and smaller than proto: %d), got: %d", max, len(protoBs), len(bs))
+		t.Fail()
+	}
+}
+
+func TestSketch_UnmarshalBinaryInPlace(t *testing.T) {
+	s := createSketch()
+	s.InsertString("test")
+
+	bs, _ := s.MarshalBinary()
+
+	into := createSketchWithPrecision(MinPrecision)
+	into.estimator = EstimatorMLE
+
+	if err := into.UnmarshalBinary(bs); err != nil {
+		t.Fatal(err)
+	}
+
+	if into.getPrecision() != DefaultPrecision || into.Estimate() != 1 {
+		t.Logf("unmarshal binary - expected precision: %d (estimate: %d), got: %d (estimate: %d)", DefaultPrecision, 1, into.getPrecision(), into.Estimate())
+		t.Fail()
+	}
+
+	// (Estimators aren't serialized, so the receiver's is kept).
+	if into.estimator != EstimatorMLE || into.biasSet != defaultBiases {
+		t.Logf("unmarshal binary - expected estimator: %v (and default biases) to be kept, got: %v", EstimatorMLE, into.estimator)
+		t.Fail()
+	}
+
+	// Nor are seeds, so the receiver's must match.
+	seeded := createSeededSketch(t, 1).(*sketch)
+	seeded.InsertString("test")
+	seededBs, _ := seeded.MarshalBinary()
+
+	if err := createSeededSketch(t, 2).(*sketch).UnmarshalBinary(seededBs); !errors.Is(err, ErrorMismatchedSeed) {
+		t.Logf("unmarshal binary - expected a receiver with a different seed to fail with: %v, got: %v", ErrorMismatchedSeed, err)
+		t.Fail()
+	}
+
+	if err := into.UnmarshalBinary(seededBs); !errors.Is(err, ErrorMismatchedSeed) {
+		t.Logf("unmarshal binary - expected an unseeded receiver to fail with: %v, got: %v", ErrorMismatchedSeed, err)
+		t.Fail()
+	}
+
+	seededInto := createSeededSketch(t, 1).(*sketch)
+
+	if err := seededInto.UnmarshalBinary(seededBs); err != nil || seededInto.seed != 1 {
+		t.Logf("unmarshal binary - expected a receiver with the same seed to keep it: %d, got: %d (err: %v)", 1, seededInto.seed, err)
+		t.Fail()
+	}
+
+	if err := into.UnmarshalBinary([]byte("garbage")); err == nil || into.Estimate() != 1 {
+		t.Logf("unmarshal binary - expected garbage to fail and leave sketch unchanged, got: %v (estimate: %d)", err, into.Estimate())
+		t.Fail()
+	}
+}
+
+func TestSketch_MarshalBinaryGob(t *testing.T) {
+	type record struct {
+		Key    string
+		Sketch Sketch
+	}
+
+	gob.Register(NewSketch())
+
+	s := createSketch()
+
+	for i := 0; i < 1_000; i++ {
+		s.InsertUint64(uint64(i))
+	}
+
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(record{Key: "test", Sketch: s}); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded record
+
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Key != "test" || decoded.Sketch.Estimate() != s.Estimate() {
+		t.Logf("marshal binary gob - expected key: %s (estimate: %d), got: %s (estimate: %d)", "test", s.Estimate(), decoded.Key, decoded.Sketch.Estimate())
+		t.Fail()
+	}
+}
+
+func TestSketch_MarshalBinaryConcurrent(t *testing.T) {
+	c := createConcurrentSketch(t)
+	ss := createShardedSketch(t, nil)
+
+	for i := 0; i < 1_000; i++ {
+		c.InsertUint64(uint64(i))
+		ss.InsertUint64(uint64(i))
+	}
+
+	for _, sk := range []interface {
+		Sketch
+		MarshalBinary() ([]byte, error)
+	}{c, ss} {
+		bs, err := sk.MarshalBinary()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := UnmarshalBinary(bs)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Estimate() != sk.Estimate() {
+			t.Logf("marshal binary (%T) - expected estimate: %d, got: %d", sk, sk.Estimate(), decoded.Estimate())
+			t.Fail()
+		}
+	}
+}
+
+func TestUnmarshalBinary_Invalid(t *testing.T) {
+	s := createSketchWithPrecision(MinPrecision)
+	valid, _ := s.MarshalBinary()
+
+	// header returns a valid header for an empty MinPrecision sketch, with its fields replaced by any of
+	// (format version, precision, encoding, flags, payload) that are non-negative.
+	header := func(fields ...int) []byte {
+		bs := append([]byte(nil), valid[:binaryHeaderSize]...)
+
+		for i, f := range fields {
+			if f >= 0 {
+				bs[len(binaryMagic)+i] = byte(f)
+			}
+		}
+
+		// (Capped, so appending to it never shares a backing array).
+		return bs[:len(bs):len(bs)]
+	}
+
+	params := func(bs []byte, fingerprint uint64, version, hasherID string) []byte {
+		bs = appendUvarint(bs, fingerprint)
+		bs = appendBinaryString(bs, version)
+		return appendBinaryString(bs, hasherID)
+	}
+
+	defaults := func(bs []byte) []byte {
+		return params(bs, 0, currentVersion, defaultHasher.ID())
+	}
+
+	runLength := header(-1, -1, -1, -1, binaryPayloadRunLength)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", nil, ErrorMalformedSketch},
+		{"magic", append([]byte("HLLX"), valid[len(binaryMagic):]...), ErrorMalformedSketch},
+		{"truncated header", valid[:binaryHeaderSize-1], ErrorMalformedSketch},
+		{"truncated params", valid[:binaryHeaderSize+2], ErrorMalformedSketch},
+		{"format version", append(header(binaryFormatVersion+1), valid[binaryHeaderSize:]...), ErrorUnsupportedVersion},
+		{"precision", append(header(-1, MaxPrecision+1), valid[binaryHeaderSize:]...), ErrorMalformedPrecision},
+		{"encoding", append(header(-1, -1, 3), valid[binaryHeaderSize:]...), ErrorMalformedSketch},
+		{"flags", append(header(-1, -1, -1, 2), valid[binaryHeaderSize:]...), ErrorMalformedSketch},
+		{"payload", append(header(-1, -1, -1, -1, 2), valid[binaryHeaderSize:]...), ErrorMalformedSketch},
+		{"version", append(params(runLength, 0, "2", defaultHasher.ID()), 16, 0), ErrorUnsupportedVersion},
+		{"string length", append(runLength, 0, 100), ErrorMalformedSketch},
+		{"packed length", append(defaults(header()), make([]byte, 4)...), ErrorMalformedSketch},
+		{"short runs", append(defaults(runLength), 15, 0), ErrorMalformedSketch},
+		{"long runs", append(defaults(runLength), 17, 0), ErrorMalformedSketch},
+		{"empty run", append(defaults(runLength), 0, 1, 16, 0), ErrorMalformedSketch},
+		{"truncated run", append(defaults(runLength), 16), ErrorMalformedSketch},
+		{"register", append(defaults(runLength), 15, 0, 1, maxRank+1), ErrorMalformedRegister},
+	}
+
+	for _, test := range tests {
+		if _, err := UnmarshalBinary(test.data); !errors.Is(err, test.expected) {
+			t.Logf("unmarshal binary (%s) - expected error: %v, got: %v", test.name, test.expected, err)
+			t.Fail()
+		}
+	}
+
+	if _, err := UnmarshalBinary(append(params(runLength, 0, currentVersion, "unregistered"), 16, 0)); !errors.Is(err, ErrorUnknownHasher) {
+		t.Logf("unmarshal binary - expected unregistered hasher to fail with: %v, got: %v", ErrorUnknownHasher, err)
+		t.Fail()
+	}
+
+	// (The largest possible rank is valid).
+	if _, err := UnmarshalBinary(append(defaults(runLength), 15, 0, 1, maxRank)); err != nil {
+		t.Logf("unmarshal binary - expected largest rank to be valid, got: %v", err)
+		t.Fail()
+	}
+}
+
+// FuzzUnmarshalBinary checks decoding arbitrary bytes never panics, and that anything it decodes re-encodes
+// stably and is sound. (Seeded from real sketches, in testdata/fuzz).
+func FuzzUnmarshalBinary(f *testing.F) {
+	f.Add([]byte("garbage"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var s Sketch
+
+		_, err := decodeFuzzed(func(seed uint64) (err error) {
+			s, err = UnmarshalBinaryWithSeed(data, seed)
+			return err
+		})
+
+		if err != nil {
+			return
+		}
+
+		bs, err := s.(*sketch).MarshalBinary()
+
+		if err != nil {
+			t.Fatalf("decoded binary sketch - unexpected error re-encoding: %v", err)
+		}
+
+		decoded, err := UnmarshalBinaryWithSeed(bs, s.getSeed())
+
+		if err != nil {
+			t.Fatalf("decoded binary sketch - unexpected error decoding re-encoded sketch: %v", err)
+		}
+
+		again, err := decoded.(*sketch).MarshalBinary()
+
+		if err != nil || !bytes.Equal(bs, again) {
+			t.Fatalf("decoded binary sketch - expected re-encoding to be stable, got: %q then: %q (err: %v)", bs, again, err)
+		}
+
+		checkDecodedSketch(t, s)
+	})
+}
@@ -0,0 +1,244 @@
+package hll
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	hllProto "github.com/kixa/hll-protobuf"
+)
+
+// registersPerWord is the number of (8 bit) registers packed into each uint32 of a ConcurrentSketch.
+const registersPerWord = 4
+
+// ConcurrentSketch is a Sketch that is safe for concurrent use. Inserts (and merges into it) update registers
+// with an atomic compare-and-swap, so never block each other. Estimates, serialization, and merges of it into
+// other Sketches take a snapshot of the registers, without blocking inserts: registers only ever increase, so
+// a snapshot holds every insert that finished before it started (and possibly some that ran alongside it),
+// which is what the same inserts into a Sketch would have given in some order.
+type ConcurrentSketch struct {
+	// words holds registersPerWord registers each, with register i in the byte at (i % registersPerWord).
+	words []uint32
+
+	// config is an empty sketch with the same configuration, used for snapshots.
+	config *sketch
+}
+
+// NewConcurrentSketch returns a new ConcurrentSketch configured by options, or DefaultSketchOptions() if
+// options is nil. ConcurrentSketches always use Encoding8Bit (dense) registers, so options.Sparse and
+// options.Encoding are ignored. An error is returned if options are otherwise invalid.
+func NewConcurrentSketch(options *SketchOptions) (*ConcurrentSketch, error) {
+	if options != nil {
+		o := *options
+		o.Sparse = false
+		o.Encoding = Encoding8Bit
+
+		options = &o
+	}
+
+	config, err := newConfigSketch(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConcurrentSketch{
+		words:  make([]uint32, config.registerCount()/registersPerWord),
+		config: config,
+	}, nil
+}
+
+// Insert inserts element into the Sketch.
+func (c *ConcurrentSketch) Insert(element []byte) {
+	c.addHash(c.config.hash(element))
+}
+
+// InsertString inserts element into the Sketch, without allocating.
+func (c *ConcurrentSketch) InsertString(element string) {
+	c.addHash(c.config.hash(stringBytes(element)))
+}
+
+// (Buffers for InsertUint64, since a ConcurrentSketch can't share a single scratch buffer).
+var uint64Buffers = sync.Pool{
+	New: func() interface{} {
+		return new([8]byte)
+	},
+}
+
+// InsertUint64 inserts the 8 byte little-endian encoding of element into the Sketch.
+func (c *ConcurrentSketch) InsertUint64(element uint64) {
+	buf := uint64Buffers.Get().(*[8]byte)
+	binary.LittleEndian.PutUint64(buf[:], element)
+
+	c.addHash(c.config.hash(buf[:]))
+
+	uint64Buffers.Put(buf)
+}
+
+// InsertHash inserts an already hashed element into the Sketch.
+func (c *ConcurrentSketch) InsertHash(h uint64) {
+	c.addHash(h)
+}
+
+// InsertMany inserts each of elements into the Sketch.
+func (c *ConcurrentSketch) InsertMany(elements [][]byte) {
+	for _, element := range elements {
+		register, zeros := getRegisterAndLeadingZeros(c.config.hash(element), c.config.precision)
+		c.setMax(register, zeros+1)
+	}
+}
+
+func (c *ConcurrentSketch) addHash(h uint64) {
+	register, zeros := getRegisterAndLeadingZeros(h, c.config.precision)
+	c.setMax(register, zeros+1)
+}
+
+// setMax sets register to v, if v is larger than its current value.
+func (c *ConcurrentSketch) setMax(register uint64, v uint8) {
+	word := &c.words[register/registersPerWord]
+	shift := (register % registersPerWord) * 8
+
+	for {
+		old := atomic.LoadUint32(word)
+
+		if uint8(old>>shift) >= v {
+			return
+		}
+
+		updated := old&^(0xFF<<shift) | uint32(v)<<shift
+
+		if atomic.CompareAndSwapUint32(word, old, updated) {
+			return
+		}
+	}
+}
+
+// Snapshot returns a (non-concurrent) Sketch holding a copy of the registers of c.
+func (c *ConcurrentSketch) Snapshot() Sketch {
+	return c.snapshot()
+}
+
+func (c *ConcurrentSketch) snapshot() *sketch {
+	s := c.config.emptyCopy()
+	registers := s.registers.(byteRegisters)
+
+	for i := range c.words {
+		word := atomic.LoadUint32(&c.words[i])
+
+		for j := 0; j < registersPerWord; j++ {
+			registers[i*registersPerWord+j] = uint8(word >> (j * 8))
+		}
+	}
+
+	return s
+}
+
+// Estimate returns the estimated cardinality of a snapshot of c.
+func (c *ConcurrentSketch) Estimate() uint64 {
+	return c.snapshot().Estimate()
+}
+
+// EstimateWith returns the estimated cardinality of a snapshot of c, calculated using estimator.
+func (c *ConcurrentSketch) EstimateWith(estimator Estimator) uint64 {
+	return c.snapshot().EstimateWith(estimator)
+}
+
+// EstimateWithBounds returns the estimated cardinality of a snapshot of c, along with its confidence interval.
+func (c *ConcurrentSketch) EstimateWithBounds(confidence float64) (estimate, lower, upper uint64) {
+	return c.snapshot().EstimateWithBounds(confidence)
+}
+
+// RelativeStandardError returns the relative standard error of estimates from this Sketch.
+func (c *ConcurrentSketch) RelativeStandardError() float64 {
+	return c.config.RelativeStandardError()
+}
+
+// Merge merges other into c, returning c for convenience. It is safe to call concurrently with inserts.
+func (c *ConcurrentSketch) Merge(other Sketch) (Sketch, error) {
+	if err := checkMergeable(c, other); err != nil {
+		return nil, err
+	}
+
+	if sp := sparseOf(other); sp != nil {
+		sp.forEach(func(register uint32, rank uint8) {
+			c.setMax(uint64(register), rank)
+		})
+
+		return c, nil
+	}
+
+	otherRegisters := other.getRegisters()
+
+	if len(otherRegisters) != c.config.registerCount() {
+		return nil, ErrorMalformedPrecision
+	}
+
+	for i, v := range otherRegisters {
+		if v > 0 {
+			c.setMax(uint64(i), v)
+		}
+	}
+
+	return c, nil
+}
+
+// Clone returns a new ConcurrentSketch holding a snapshot of c.
+func (c *ConcurrentSketch) Clone() Sketch {
+	s := c.snapshot()
+	registers := s.registers.(byteRegisters)
+
+	clone := &ConcurrentSketch{
+		words:  make([]uint32, len(c.words)),
+		config: c.config,
+	}
+
+	for i, v := range registers {
+		clone.words[i/registersPerWord] |= uint32(v) << ((i % registersPerWord) * 8)
+	}
+
+	return clone
+}
+
+// ProtoSketch returns a protobuf compatible version of a snapshot of c.
+func (c *ConcurrentSketch) ProtoSketch() *hllProto.Sketch {
+	return c.snapshot().ProtoSketch()
+}
+
+// ProtoSerialize returns a snapshot of c as protobuf []byte.
+func (c *ConcurrentSketch) ProtoSerialize() ([]byte, error) {
+	return c.snapshot().ProtoSerialize()
+}
+
+// MarshalBinary returns a snapshot of c in the compact binary format, which can be read back (as a
+// non-concurrent Sketch) by UnmarshalBinary.
+func (c *ConcurrentSketch) MarshalBinary() ([]byte, error) {
+	return c.snapshot().MarshalBinary()
+}
+
+func (c *ConcurrentSketch) getRegisters() []uint8 {
+	return c.snapshot().getRegisters()
+}
+
+func (c *ConcurrentSketch) getPrecision() uint8 {
+	return c.config.precision
+}
+
+func (c *ConcurrentSketch) getHasher() Hasher {
+	return c.config.hasher
+}
+
+func (c *ConcurrentSketch) getSeed() uint64 {
+	return c.config.seed
+}
+
+func (c *ConcurrentSketch) getSeedFingerprint() uint64 {
+	return c.config.seedFingerprint
+}
+
+func (c *ConcurrentSketch) getVersion() string {
+	return c.config.version
+}
+
+func (c *ConcurrentSketch) emptyCopy() *sketch {
+	return c.config.emptyCopy()
+}
@@ -0,0 +1,195 @@
+package hll
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func createConcurrentSketch(t *testing.T) *ConcurrentSketch {
+	c, err := NewConcurrentSketch(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestConcurrentSketch_MatchesSketch(t *testing.T) {
+	rand.Seed(0)
+
+	elements := make([][]byte, 100_000)
+
+	for i := range elements {
+		elements[i] = []byte(genPseudoRandomStr())
+	}
+
+	expected := createSketch()
+	expected.InsertMany(elements)
+
+	c := createConcurrentSketch(t)
+
+	const goroutines = 8
+	var wg sync.WaitGroup
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := g; i < len(elements); i += goroutines {
+				c.Insert(elements[i])
+
+				// Snapshots shouldn't interfere with inserts.
+				if i%10_000 == 0 {
+					c.Estimate()
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	if c.Estimate() != expected.Estimate() {
+		t.Logf("concurrent sketch - expected estimate: %d, got: %d", expected.Estimate(), c.Estimate())
+		t.Fail()
+	}
+
+	registers := c.getRegisters()
+
+	for i, r := range expected.getRegisters() {
+		if registers[i] != r {
+			t.Fatalf("concurrent sketch - register %d expected: %d, got: %d", i, r, registers[i])
+		}
+	}
+}
+
+func TestConcurrentSketch_InsertTyped(t *testing.T) {
+	expected := createSketch()
+	c := createConcurrentSketch(t)
+
+	expected.InsertString("test")
+	expected.InsertUint64(42)
+	expected.InsertHash(7)
+	expected.InsertMany([][]byte{[]byte("a"), []byte("b")})
+
+	c.InsertString("test")
+	c.InsertUint64(42)
+	c.InsertHash(7)
+	c.InsertMany([][]byte{[]byte("a"), []byte("b")})
+
+	registers := c.getRegisters()
+
+	for i, r := range expected.getRegisters() {
+		if registers[i] != r {
+			t.Fatalf("concurrent sketch typed insert - register %d expected: %d, got: %d", i, r, registers[i])
+		}
+	}
+}
+
+func TestConcurrentSketch_Merge(t *testing.T) {
+	rand.Seed(0)
+
+	c := createConcurrentSketch(t)
+	dense := createSketch()
+	sparse := createSparseSketch(t)
+
+	for i := 0; i < 50_000; i++ {
+		c.Insert([]byte(genPseudoRandomStr()))
+		dense.Insert([]byte(genPseudoRandomStr()))
+	}
+
+	for i := 0; i < 100; i++ {
+		sparse.Insert([]byte(genPseudoRandomStr()))
+	}
+
+	// Into a Sketch...
+	s := createSketch()
+
+	if _, err := s.Merge(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// ...and into a ConcurrentSketch.
+	for _, other := range []Sketch{dense, sparse} {
+		if _, err := c.Merge(other); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !acceptableEstimate(50_000, s.Estimate()) {
+		t.Logf("concurrent sketch merge - expected a cardinality +/-3%% of: %d, got: %d", 50_000, s.Estimate())
+		t.Fail()
+	}
+
+	if !acceptableEstimate(100_100, c.Estimate()) {
+		t.Logf("concurrent sketch merge - expected a cardinality +/-3%% of: %d, got: %d", 100_100, c.Estimate())
+		t.Fail()
+	}
+
+	_, err := c.Merge(createSketchWithPrecision(DefaultPrecision - 1))
+
+	if err != ErrorMalformedPrecision {
+		t.Logf("concurrent sketch merge - expected different precisions to fail with: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
+
+func TestConcurrentSketch_Clone(t *testing.T) {
+	c := createConcurrentSketch(t)
+	c.Insert([]byte("test"))
+
+	clone := c.Clone()
+	clone.Insert([]byte("other"))
+
+	if c.Estimate() != 1 || clone.Estimate() != 2 {
+		t.Logf("concurrent sketch clone - expected estimates: %d (and clone: %d), got: %d (and clone: %d)", 1, 2, c.Estimate(), clone.Estimate())
+		t.Fail()
+	}
+}
+
+func TestConcurrentSketch_ProtoRoundTrip(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Precision = 10
+	options.Seed = 1
+
+	c, err := NewConcurrentSketch(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		c.InsertUint64(uint64(i))
+	}
+
+	bs, err := c.ProtoSerialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ProtoDeserializeWithSeed(bs, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Estimate() != c.Estimate() || s.getSeedFingerprint() != seedFingerprint(1) || s.getPrecision() != 10 {
+		t.Logf("concurrent sketch proto - expected estimate: %d (seed fingerprint: %#x, precision: %d), got: %d (seed fingerprint: %#x, precision: %d)", c.Estimate(), seedFingerprint(1), 10, s.Estimate(), s.getSeedFingerprint(), s.getPrecision())
+		t.Fail()
+	}
+}
+
+func TestNewConcurrentSketch_Invalid(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Precision = MaxPrecision + 1
+
+	_, err := NewConcurrentSketch(options)
+
+	if err == nil {
+		t.Fatalf("new concurrent sketch - expected invalid precision to error, but did not")
+	}
+}
@@ -0,0 +1,330 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+)
+
+// Estimator selects how a Sketch turns its registers into a cardinality estimate. It has no effect on
+// inserts, merges or serialization - Sketches with different estimators can be freely merged together.
+type Estimator int
+
+const (
+	// EstimatorBiasCorrected uses HLL++ style estimation: linear counting for small cardinalities, then the raw
+	// harmonic estimate corrected by interpolating the Sketch's biases. This is the default.
+	EstimatorBiasCorrected Estimator = iota
+
+	// EstimatorErtl uses the improved raw estimator from Otmar Ertl's "New cardinality estimation algorithms
+	// for HyperLogLog sketches" (https://arxiv.org/abs/1702.01284), with the per-register-count alpha of the
+	// raw estimate at precisions below 7. It needs no biases, and is accurate across all cardinalities at every
+	// precision.
+	EstimatorErtl
+
+	// EstimatorMLE uses maximum-likelihood estimation over the register values, as in Otmar Ertl's "New
+	// cardinality estimation algorithms for HyperLogLog sketches". It is slightly more accurate than
+	// EstimatorErtl (particularly for merged Sketches), but costs several passes over the register histogram.
+	EstimatorMLE
+)
+
+func (e Estimator) valid() bool {
+	return e >= EstimatorBiasCorrected && e <= EstimatorMLE
+}
+
+func (e Estimator) String() string {
+	switch e {
+	case EstimatorBiasCorrected:
+		return "bias_corrected"
+	case EstimatorErtl:
+		return "ertl"
+	case EstimatorMLE:
+		return "mle"
+	}
+
+	return fmt.Sprintf("Estimator(%d)", int(e))
+}
+
+// estimateBranch records which method produced an estimate, since each has a different error distribution.
+type estimateBranch int
+
+const (
+	branchLinearCounting estimateBranch = iota
+	branchBiasCorrected
+	branchRaw
+)
+
+// estimateBranchOf returns estimate with the branch the bias corrected estimator would have used for it.
+// (Both EstimatorErtl and EstimatorMLE perform like linear counting for small cardinalities).
+func (s *sketch) estimateBranchOf(estimate uint64) (uint64, estimateBranch) {
+	if estimate < maxLinearCounting[s.precision-MinPrecision] {
+		return estimate, branchLinearCounting
+	}
+
+	return estimate, branchRaw
+}
+
+// RelativeStandardError returns the relative standard error of estimates from this Sketch.
+func (s *sketch) RelativeStandardError() float64 {
+	return relativeStandardError(s.precision)
+}
+
+func relativeStandardError(precision uint8) float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<precision))
+}
+
+// EstimateWithBounds returns the estimated cardinality of this Sketch (as Estimate), along with the bounds of
+// its (two-sided) confidence interval at confidence. confidence is clamped to [0, 1], and NaN is treated as 0.
+func (s *sketch) EstimateWithBounds(confidence float64) (estimate, lower, upper uint64) {
+	estimate, branch := s.estimate(s.estimator)
+
+	// (math.Max and math.Min both return NaN if either argument is).
+	if math.IsNaN(confidence) {
+		confidence = 0
+	}
+
+	confidence = math.Max(0, math.Min(1, confidence))
+	z := math.Sqrt2 * math.Erfinv(confidence)
+
+	delta := z * s.standardDeviation(estimate, branch)
+
+	// (A confidence of 1 is an infinite z, which makes no difference to a standard deviation of 0).
+	if math.IsNaN(delta) {
+		delta = 0
+	}
+	lowerF := math.Max(0, float64(estimate)-delta)
+	upperF := float64(estimate) + delta
+
+	// (A saturated estimate of math.MaxUint64 rounds up to 2^64 as a float64, so either bound can overflow).
+	if lowerF >= math.MaxUint64 {
+		return estimate, math.MaxUint64, math.MaxUint64
+	}
+
+	if upperF >= math.MaxUint64 {
+		return estimate, uint64(lowerF), math.MaxUint64
+	}
+
+	return estimate, uint64(lowerF), uint64(math.Ceil(upperF))
+}
+
+// standardDeviation returns the (absolute) standard deviation of estimate, which was produced by branch.
+func (s *sketch) standardDeviation(estimate uint64, branch estimateBranch) float64 {
+	if branch == branchLinearCounting {
+		// (From "A Linear-Time Probabilistic Counting Algorithm for Database Applications", Whang et al.)
+		mf := float64(s.registerCount())
+		t := float64(estimate) / mf
+
+		return math.Sqrt(mf * (math.Exp(t) - t - 1))
+	}
+
+	return s.RelativeStandardError() * float64(estimate)
+}
+
+// histogram returns the number of registers holding each value, from 0 (untouched) up to the largest
+// possible value (maxRankAt(precision)). For dense registers this is cached (see sketch.counts), so
+// the result must not be modified.
+func (s *sketch) histogram() []int {
+	if s.counts != nil {
+		return s.counts
+	}
+
+	counts := make([]int, int(maxRankAt(s.precision))+1)
+
+	// (Sparse registers are small, and change on every insert, so aren't worth caching).
+	if s.sparse != nil {
+		counts[0] = s.registerCount() - s.sparse.count()
+
+		s.sparse.forEach(func(_ uint32, rank uint8) {
+			counts[rank] += 1
+		})
+
+		return counts
+	}
+
+	if b, ok := s.registers.(byteRegisters); ok {
+		countBytes(b, counts)
+	} else {
+		for i := 0; i < s.registers.count(); i++ {
+			counts[s.registers.get(uint64(i))] += 1
+		}
+	}
+
+	s.counts = counts
+
+	return counts
+}
+
+// ertlEstimate returns the improved raw estimate (see EstimatorErtl).
+// (Algorithm 6 in: https://arxiv.org/abs/1702.01284)
+func (s *sketch) ertlEstimate() uint64 {
+	counts := s.histogram()
+	q := len(counts) - 2
+	mf := float64(s.registerCount())
+
+	z := mf * ertlTau(1-float64(counts[q+1])/mf)
+
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + float64(counts[k]))
+	}
+
+	// Ertl's estimator uses alpha throughout, which overestimates with fewer than 128 registers. (The sum so far
+	// is the raw harmonic part, so scaling it is the same as using alphaAt there, while the correction for
+	// untouched registers below is exact at any precision).
+	z *= alpha / alphaAt(s.registerCount())
+
+	z += mf * ertlSigma(float64(counts[0])/mf)
+
+	// (When every register is untouched, z is +Inf and so the estimate is 0. When every register is saturated, z
+	// is 0 and so the estimate is +Inf, which is clamped as the raw estimate is).
+	estimate := math.Round(alpha * mf * mf / z)
+
+	if estimate >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+
+	return uint64(estimate)
+}
+
+// ertlSigma corrects the estimate for untouched registers, x being the fraction of registers that are.
+func ertlSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+
+	for {
+		x *= x
+		previous := z
+		z += x * y
+		y += y
+
+		if z == previous {
+			return z
+		}
+	}
+}
+
+// ertlTau corrects the estimate for saturated registers, x being the fraction of registers that aren't.
+func ertlTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y := 1.0
+	z := 1 - x
+
+	for {
+		x = math.Sqrt(x)
+		previous := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+
+		if z == previous {
+			return z / 3
+		}
+	}
+}
+
+// mleEstimate returns the maximum-likelihood estimate (see EstimatorMLE), found via the secant method.
+// (Algorithm 8 in: https://arxiv.org/abs/1702.01284)
+func (s *sketch) mleEstimate() uint64 {
+	counts := s.histogram()
+	q := len(counts) - 2
+	mf := float64(s.registerCount())
+
+	// Every register is saturated, so the likelihood has no maximum.
+	if counts[q+1] == s.registerCount() {
+		return math.MaxUint64
+	}
+
+	kMin, kMax := 0, 0
+
+	for k := len(counts) - 1; k >= 0; k-- {
+		if counts[k] > 0 {
+			kMin = k
+		}
+	}
+
+	for k := 0; k < len(counts); k++ {
+		if counts[k] > 0 {
+			kMax = k
+		}
+	}
+
+	if kMin < 1 {
+		kMin = 1
+	}
+
+	if kMax > q {
+		kMax = q
+	}
+
+	var z float64
+
+	for k := kMax; k >= kMin; k-- {
+		z = 0.5*z + float64(counts[k])
+	}
+
+	z = math.Ldexp(z, -kMin)
+
+	c := float64(counts[q+1])
+
+	if q >= 1 {
+		c += float64(counts[kMax])
+	}
+
+	a := z + float64(counts[0])
+	b := z + math.Ldexp(float64(counts[q+1]), -q)
+	touched := mf - float64(counts[0])
+
+	var x float64
+
+	if b <= 1.5*a {
+		x = touched / (0.5*b + a)
+	} else {
+		x = touched / b * math.Log1p(b/a)
+	}
+
+	epsilon := 0.01 / math.Sqrt(mf)
+	dx := x
+	var gPrevious float64
+
+	for dx > x*epsilon {
+		kappa := 2 + int(math.Floor(math.Log2(x)))
+
+		scale := kMax
+		if kappa > scale {
+			scale = kappa
+		}
+
+		x1 := math.Ldexp(x, -scale-1)
+		x2 := x1 * x1
+		h := x1 - x2/3 + (x2*x2)*(1.0/45-x2/472.5)
+
+		for k := kappa - 1; k >= kMax; k-- {
+			h = (x1 + h*(1-h)) / (x1 + (1 - h))
+			x1 *= 2
+		}
+
+		g := c * h
+
+		for k := kMax - 1; k >= kMin; k-- {
+			h = (x1 + h*(1-h)) / (x1 + (1 - h))
+			g += float64(counts[k]) * h
+			x1 *= 2
+		}
+
+		g += x * a
+
+		if g > gPrevious && touched >= g {
+			dx *= (touched - g) / (g - gPrevious)
+		} else {
+			dx = 0
+		}
+
+		x += dx
+		gPrevious = g
+	}
+
+	return uint64(math.Round(mf * x))
+}
@@ -0,0 +1,375 @@
+package hll
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func createEstimatorSketch(t *testing.T, precision uint8, estimator Estimator, sparse bool) *sketch {
+	options := DefaultSketchOptions()
+	options.Precision = precision
+	options.Estimator = estimator
+	options.Sparse = sparse
+
+	s, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*sketch)
+}
+
+// withinStandardErrors returns whether estimate is within n standard errors (1.04/sqrt(registers)) of expected.
+// (Off by one is always accepted, since small cardinalities can be within a fraction of a standard error).
+func withinStandardErrors(expected, estimate uint64, registers int, n float64) bool {
+	delta := math.Abs(float64(estimate) - float64(expected))
+
+	return delta <= math.Max(1, n*1.04/math.Sqrt(float64(registers))*float64(expected))
+}
+
+func TestSketch_EstimateErtl(t *testing.T) {
+	runEstimatorAccuracy(t, EstimatorErtl)
+}
+
+func TestSketch_EstimateMLE(t *testing.T) {
+	runEstimatorAccuracy(t, EstimatorMLE)
+}
+
+func runEstimatorAccuracy(t *testing.T, estimator Estimator) {
+	rand.Seed(0)
+
+	for _, precision := range []uint8{MinPrecision, 8, 12, DefaultPrecision, MaxPrecision} {
+		s := createEstimatorSketch(t, precision, estimator, false)
+
+		var inserted uint64
+
+		for _, cardinality := range []uint64{10, 100, 1_000, 10_000, 100_000, 1_000_000} {
+			for ; inserted < cardinality; inserted++ {
+				s.addHash(rand.Uint64())
+			}
+
+			if !withinStandardErrors(cardinality, s.Estimate(), s.registerCount(), 4) {
+				t.Logf("%v estimate (precision: %d) - expected a cardinality close to: %d, got: %d", estimator, precision, cardinality, s.Estimate())
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestSketch_EstimateErtlLowPrecisionBias(t *testing.T) {
+	const trials = 4_000
+
+	// (Both while some registers are untouched, and once none are).
+	for p := uint8(MinPrecision); p <= 6; p++ {
+		for _, cardinality := range []int{4 << p, 2_000} {
+			bias := meanRelativeError(p, EstimatorErtl, cardinality, trials)
+
+			if limit := 4 * 1.04 / math.Sqrt(float64(trials*(int(1)<<p))); math.Abs(bias) > limit {
+				t.Logf("ertl estimate (p=%d, cardinality: %d) - expected a mean relative error within: %.4f, got: %.4f", p, cardinality, limit, bias)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestSketch_EstimateEmpty(t *testing.T) {
+	for _, estimator := range []Estimator{EstimatorErtl, EstimatorMLE} {
+		for _, sparse := range []bool{false, true} {
+			s := createEstimatorSketch(t, DefaultPrecision, estimator, sparse)
+
+			if s.Estimate() != 0 {
+				t.Logf("%v estimate (sparse: %v) - expected empty sketch to estimate: %d, got: %d", estimator, sparse, 0, s.Estimate())
+				t.Fail()
+			}
+		}
+	}
+}
+
+// createSaturatedSketch returns a sketch at precision with every register holding the largest possible rank.
+func createSaturatedSketch(t *testing.T, precision uint8, estimator Estimator) *sketch {
+	s := createEstimatorSketch(t, precision, estimator, false)
+
+	for i := 0; i < s.registerCount(); i++ {
+		s.registers.set(uint64(i), maxRankAt(precision))
+	}
+
+	return s
+}
+
+func TestSketch_EstimateSaturated(t *testing.T) {
+	for _, estimator := range []Estimator{EstimatorBiasCorrected, EstimatorErtl, EstimatorMLE} {
+		for _, precision := range []uint8{MinPrecision, DefaultPrecision, MaxPrecision} {
+			s := createSaturatedSketch(t, precision, estimator)
+
+			if s.Estimate() != math.MaxUint64 {
+				t.Logf("%v estimate (precision: %d) - expected saturated sketch to estimate: %d, got: %d", estimator, precision, uint64(math.MaxUint64), s.Estimate())
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestSketch_EstimateSparse(t *testing.T) {
+	rand.Seed(0)
+
+	sparse := createEstimatorSketch(t, DefaultPrecision, EstimatorBiasCorrected, true)
+	dense := createEstimatorSketch(t, DefaultPrecision, EstimatorBiasCorrected, false)
+
+	for i := 0; i < 1_000; i++ {
+		h := rand.Uint64()
+
+		sparse.addHash(h)
+		dense.addHash(h)
+	}
+
+	for _, estimator := range []Estimator{EstimatorErtl, EstimatorMLE} {
+		if sparse.EstimateWith(estimator) != dense.EstimateWith(estimator) {
+			t.Logf("%v estimate - expected sparse estimate to match dense: %d, got: %d", estimator, dense.EstimateWith(estimator), sparse.EstimateWith(estimator))
+			t.Fail()
+		}
+	}
+}
+
+func TestSketch_EstimateWith(t *testing.T) {
+	rand.Seed(0)
+
+	s := createSketch()
+
+	for i := 0; i < 50_000; i++ {
+		s.Insert([]byte(genPseudoRandomStr()))
+	}
+
+	if s.EstimateWith(EstimatorBiasCorrected) != s.Estimate() {
+		t.Logf("estimate with - expected default estimator to match Estimate: %d, got: %d", s.Estimate(), s.EstimateWith(EstimatorBiasCorrected))
+		t.Fail()
+	}
+
+	for _, estimator := range []Estimator{EstimatorErtl, EstimatorMLE} {
+		if !acceptableEstimate(50_000, s.EstimateWith(estimator)) {
+			t.Logf("estimate with (%v) - expected a cardinality +/-3%% of: %d, got: %d", estimator, 50_000, s.EstimateWith(estimator))
+			t.Fail()
+		}
+	}
+}
+
+func TestSketch_EstimateMLEMerged(t *testing.T) {
+	rand.Seed(0)
+
+	sketches := make([]Sketch, 10)
+
+	for i := range sketches {
+		sketches[i] = createSketch()
+
+		// Overlapping ranges, so the rollup has 100,000 uniques.
+		for j := i * 10_000; j < i*10_000+20_000 && j < 100_000; j++ {
+			sketches[i].InsertUint64(uint64(j))
+		}
+	}
+
+	s, err := Rollup(sketches)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !acceptableEstimate(100_000, s.EstimateWith(EstimatorMLE)) {
+		t.Logf("mle estimate - expected a rollup cardinality +/-3%% of: %d, got: %d", 100_000, s.EstimateWith(EstimatorMLE))
+		t.Fail()
+	}
+}
+
+func TestSketch_Histogram(t *testing.T) {
+	s := createSketchWithPrecision(MinPrecision)
+	s.registers.set(0, 1)
+	s.registers.set(1, 1)
+	s.registers.set(2, 61)
+
+	counts := s.histogram()
+
+	if len(counts) != maxRank+1 {
+		t.Fatalf("histogram - expected length: %d, got: %d", maxRank+1, len(counts))
+	}
+
+	if counts[0] != 13 || counts[1] != 2 || counts[61] != 1 {
+		t.Fatalf("histogram - expected counts (0: %d, 1: %d, 61: %d), got: (0: %d, 1: %d, 61: %d)", 13, 2, 1, counts[0], counts[1], counts[61])
+	}
+}
+
+func TestSketch_HistogramCached(t *testing.T) {
+	rand.Seed(0)
+
+	for _, encoding := range testEncodings {
+		options := DefaultSketchOptions()
+		options.Encoding = encoding
+		options.Sparse = true
+
+		s, err := NewSketchWithOptions(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other := createSketch()
+
+		// Estimates (which cache the histogram once dense) between inserts, merges and clones should always
+		// match a fresh count of the registers.
+		for i := 0; i < 200_000; i++ {
+			s.InsertUint64(rand.Uint64())
+			other.InsertUint64(rand.Uint64())
+
+			if i%10_000 == 0 {
+				s.Estimate()
+			}
+
+			if i == 100_000 {
+				sparse := createEstimatorSketch(t, DefaultPrecision, EstimatorBiasCorrected, true)
+
+				for j := 0; j < 100; j++ {
+					sparse.InsertUint64(rand.Uint64())
+				}
+
+				for _, o := range []Sketch{other, sparse} {
+					if _, err := s.Merge(o); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// (Merges keep the histogram up to date, rather than dropping it).
+				if s.(*sketch).counts == nil {
+					t.Fatalf("cached histogram (%v) - expected merges to keep the histogram cached", encoding)
+				}
+
+				s.Estimate()
+				s = s.Clone()
+			}
+		}
+
+		cached := s.(*sketch).histogram()
+		fresh := s.(*sketch).Clone().(*sketch)
+		fresh.counts = nil
+
+		for k, count := range fresh.histogram() {
+			if cached[k] != count {
+				t.Fatalf("cached histogram (%v) - expected count of %d: %d, got: %d", encoding, k, count, cached[k])
+			}
+		}
+	}
+}
+
+func TestNewSketchWithOptions_InvalidEstimator(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Estimator = EstimatorMLE + 1
+
+	_, err := NewSketchWithOptions(options)
+
+	if err == nil {
+		t.Fatalf("new sketch with options - expected unknown estimator to error, but did not")
+	}
+}
+
+func TestSketch_RelativeStandardError(t *testing.T) {
+	for precision := uint8(MinPrecision); precision <= MaxPrecision; precision++ {
+		s := createSketchWithPrecision(precision)
+		expected := 1.04 / math.Sqrt(float64(s.registerCount()))
+
+		if s.RelativeStandardError() != expected {
+			t.Logf("relative standard error (precision: %d) - expected: %v, got: %v", precision, expected, s.RelativeStandardError())
+			t.Fail()
+		}
+	}
+}
+
+func TestSketch_EstimateWithBounds(t *testing.T) {
+	rand.Seed(0)
+
+	s := createSketch()
+
+	for i := 0; i < 50_000; i++ {
+		s.addHash(rand.Uint64())
+	}
+
+	estimate, lower, upper := s.EstimateWithBounds(0.95)
+
+	if estimate != s.Estimate() || lower > estimate || upper < estimate {
+		t.Fatalf("estimate with bounds - expected %d to be within: [%d, %d] and match Estimate: %d", estimate, lower, upper, s.Estimate())
+	}
+
+	_, wideLower, wideUpper := s.EstimateWithBounds(0.99)
+
+	if wideLower >= lower || wideUpper <= upper {
+		t.Logf("estimate with bounds - expected 99%% bounds to be wider than [%d, %d], got: [%d, %d]", lower, upper, wideLower, wideUpper)
+		t.Fail()
+	}
+
+	for _, confidence := range []float64{0, -1, math.NaN()} {
+		_, lower, upper = s.EstimateWithBounds(confidence)
+
+		if lower != estimate || upper != estimate {
+			t.Logf("estimate with bounds (confidence: %v) - expected bounds to be: [%d, %d], got: [%d, %d]", confidence, estimate, estimate, lower, upper)
+			t.Fail()
+		}
+	}
+
+	if _, lower, upper = s.EstimateWithBounds(1); lower != 0 || upper != math.MaxUint64 {
+		t.Logf("estimate with bounds - expected 100%% bounds to be: [%d, %d], got: [%d, %d]", uint64(0), uint64(math.MaxUint64), lower, upper)
+		t.Fail()
+	}
+
+	empty := createSketch()
+
+	if estimate, lower, upper := empty.EstimateWithBounds(1); estimate != 0 || lower != 0 || upper != 0 {
+		t.Logf("estimate with bounds - expected empty sketch at 100%% to be: 0 [0, 0], got: %d [%d, %d]", estimate, lower, upper)
+		t.Fail()
+	}
+}
+
+func TestSketch_EstimateWithBoundsSaturated(t *testing.T) {
+	for _, estimator := range []Estimator{EstimatorBiasCorrected, EstimatorErtl, EstimatorMLE} {
+		s := createSaturatedSketch(t, MinPrecision, estimator)
+
+		if estimate, lower, upper := s.EstimateWithBounds(0); estimate != math.MaxUint64 || lower != estimate || upper != estimate {
+			t.Logf("%v estimate with bounds - expected saturated sketch at 0%% to be: %d [%d, %d], got: %d [%d, %d]", estimator, uint64(math.MaxUint64), uint64(math.MaxUint64), uint64(math.MaxUint64), estimate, lower, upper)
+			t.Fail()
+		}
+
+		if estimate, lower, upper := s.EstimateWithBounds(0.95); lower >= estimate || upper != estimate {
+			t.Logf("%v estimate with bounds - expected saturated sketch at 95%% to have an upper bound of: %d (and a lower one below it), got: %d [%d, %d]", estimator, uint64(math.MaxUint64), estimate, lower, upper)
+			t.Fail()
+		}
+	}
+}
+
+// The 95% intervals should contain the true cardinality ~95% of the time, for both linear counting and bias
+// corrected estimates.
+func TestSketch_EstimateWithBoundsCoverage(t *testing.T) {
+	rand.Seed(0)
+
+	const trials = 200
+
+	for _, estimator := range []Estimator{EstimatorBiasCorrected, EstimatorErtl, EstimatorMLE} {
+		for _, cardinality := range []uint64{300, 20_000} {
+			covered := 0
+
+			for trial := 0; trial < trials; trial++ {
+				s := createEstimatorSketch(t, 10, estimator, false)
+
+				for i := uint64(0); i < cardinality; i++ {
+					s.addHash(rand.Uint64())
+				}
+
+				_, lower, upper := s.EstimateWithBounds(0.95)
+
+				if lower <= cardinality && cardinality <= upper {
+					covered += 1
+				}
+			}
+
+			if covered < trials*85/100 {
+				t.Logf("estimate with bounds (%v) - expected 95%% bounds to cover %d at least 85%% of the time, got: %d/%d", estimator, cardinality, covered, trials)
+				t.Fail()
+			}
+		}
+	}
+}
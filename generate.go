@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-
-	"github.com/zeebo/xxh3"
 )
 
 const (
@@ -30,6 +28,10 @@ type GenerationOptions struct {
 
 	InitialStep int
 	StepRate    float64
+
+	// Hasher is used to hash the output of fn. If nil, XXH3Hasher is used. This should match the Hasher
+	// used by any Sketches using the generated biases.
+	Hasher Hasher
 }
 
 // DefaultGenerationOptions returns a copy of the default GenerationOptions.
@@ -41,6 +43,8 @@ func DefaultGenerationOptions() *GenerationOptions {
 
 		InitialStep: 50,
 		StepRate:    1.25,
+
+		Hasher: defaultHasher,
 	}
 }
 
@@ -78,6 +82,11 @@ func GenerateBiases(fn func() []byte, options *GenerationOptions) ([]*BiasEstima
 		return nil, errors.New("invalid options: step rate must be greater than 0")
 	}
 
+	hasher := options.Hasher
+	if hasher == nil {
+		hasher = defaultHasher
+	}
+
 	verbose := false
 	if os.Getenv(genBiasVerboseFlag) == "1" {
 		verbose = true
@@ -91,7 +100,7 @@ func GenerateBiases(fn func() []byte, options *GenerationOptions) ([]*BiasEstima
 		log.Printf("generateBiases - Generating test sets...")
 	}
 
-	sets := generateSets(fn, options.MaxCardinality, options.Repeats, verbose)
+	sets := generateSets(fn, hasher, options.MaxCardinality, options.Repeats, verbose)
 
 	for i, cardinality := range cardinalities {
 		theseEstimates := make([]int, options.Repeats)
@@ -157,10 +166,10 @@ func calculateInterpolationPoints(maxCardinality uint64, initialStep int, stepRa
 	return ticks[1:]
 }
 
-// generateSets returns repeats number of []uint64's containing maxCardinality unique hashes produced
-// from fn.
+// generateSets returns repeats number of []uint64's containing maxCardinality unique hashes (using hasher)
+// produced from fn.
 // (As above, if fn produces less uniques than maxCardinality, this will never end)
-func generateSets(fn func() []byte, maxCardinality uint64, repeats int, verbose bool) [][]uint64 {
+func generateSets(fn func() []byte, hasher Hasher, maxCardinality uint64, repeats int, verbose bool) [][]uint64 {
 	var sets [][]uint64
 
 	for i := 0; i < repeats; i++ {
@@ -172,7 +181,7 @@ func generateSets(fn func() []byte, maxCardinality uint64, repeats int, verbose
 
 		totalUniquesGenerated := uint64(0)
 		for totalUniquesGenerated < maxCardinality {
-			candidate := xxh3.Hash(fn())
+			candidate := hasher.Hash(fn())
 
 			_, exists := uniques[candidate]
 
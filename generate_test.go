@@ -120,7 +120,7 @@ func TestCalculateInterpolationPoints(t *testing.T) {
 }
 
 func TestGenerateSets(t *testing.T) {
-	res := generateSets(testBiasFn, 10, 2, false)
+	res := generateSets(testBiasFn, defaultHasher, 10, 2, false)
 
 	if res == nil {
 		t.Fatalf("generate sets - expected sets to be returned, got nil")
@@ -1,6 +1,6 @@
 module github.com/kixa/hll-go
 
-go 1.17
+go 1.18
 
 require github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 
@@ -0,0 +1,238 @@
+package hll
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Hasher is a 64-bit hash function used to place elements into a Sketch's registers. Sketches record the ID
+// of the Hasher they were created with, and can only be merged with Sketches using a Hasher with the same ID.
+type Hasher interface {
+	// ID uniquely identifies this hash function (including any parameters that change its output). It must be
+	// non-empty and must not contain ';' or '='.
+	ID() string
+
+	// Hash returns the 64-bit hash of element. It must not modify or retain element.
+	Hash(element []byte) uint64
+}
+
+// SeededHasher is a Hasher that can also hash elements with a seed (see SketchOptions.Seed).
+type SeededHasher interface {
+	Hasher
+
+	// HashSeed returns the 64-bit hash of element, using seed. It must not modify or retain element.
+	HashSeed(element []byte, seed uint64) uint64
+}
+
+// XXH3Hasher hashes elements using 64-bit XXH3. This is the default Hasher.
+type XXH3Hasher struct{}
+
+// ID returns "xxh3".
+func (XXH3Hasher) ID() string {
+	return "xxh3"
+}
+
+// Hash returns the 64-bit XXH3 hash of element.
+func (XXH3Hasher) Hash(element []byte) uint64 {
+	return xxh3.Hash(element)
+}
+
+// HashSeed returns the 64-bit XXH3 hash of element, using seed.
+func (XXH3Hasher) HashSeed(element []byte, seed uint64) uint64 {
+	return xxh3.HashSeed(element, seed)
+}
+
+// Murmur3Hasher hashes elements using MurmurHash3 (x64_128, with a seed of 0), keeping the first 64 bits. This
+// is the same hash function as postgresql-hll and Druid, but not the same registers: Sketches index registers by
+// the high bits of a hash and rank by leading zeros, whereas postgresql-hll uses the low bits and trailing zeros.
+// So Sketches using it are not bit-compatible with (and can't be merged with) those systems' sketches.
+type Murmur3Hasher struct{}
+
+// ID returns "murmur3_128".
+func (Murmur3Hasher) ID() string {
+	return "murmur3_128"
+}
+
+// Hash returns the first 64 bits of the MurmurHash3 (x64_128) hash of element.
+func (Murmur3Hasher) Hash(element []byte) uint64 {
+	h1, _ := murmur3Sum128(element, 0)
+	return h1
+}
+
+// redisMurmur64ASeed is the seed used by Redis when hashing elements for its HyperLogLog.
+const redisMurmur64ASeed = 0xadc83b19
+
+// Murmur64AHasher hashes elements using MurmurHash64A with the same seed as Redis (0xadc83b19). This is the same
+// hash as Redis' PFADD, but (like Murmur3Hasher) not the same registers, since Redis indexes registers by the low
+// bits of a hash and ranks by trailing zeros.
+type Murmur64AHasher struct{}
+
+// ID returns "murmur64a".
+func (Murmur64AHasher) ID() string {
+	return "murmur64a"
+}
+
+// Hash returns the MurmurHash64A hash of element.
+func (Murmur64AHasher) Hash(element []byte) uint64 {
+	return murmur64A(element, redisMurmur64ASeed)
+}
+
+// HashSeed returns the MurmurHash64A hash of element, using seed (in place of the Redis seed).
+func (Murmur64AHasher) HashSeed(element []byte, seed uint64) uint64 {
+	return murmur64A(element, seed)
+}
+
+var defaultHasher Hasher = XXH3Hasher{}
+
+// Package level store for Hashers, keyed by ID. This is used to find the Hasher for a deserialized Sketch.
+var hasherStore = map[string]Hasher{
+	XXH3Hasher{}.ID():      XXH3Hasher{},
+	Murmur3Hasher{}.ID():   Murmur3Hasher{},
+	Murmur64AHasher{}.ID(): Murmur64AHasher{},
+}
+
+// RegisterHasher allows a custom Hasher to be used when deserializing Sketches that were created with it.
+// (The built-in Hashers are always registered).
+func RegisterHasher(hasher Hasher) error {
+	if hasher == nil {
+		return fmt.Errorf("invalid hasher: must not be nil")
+	}
+
+	if !validHasherID(hasher.ID()) {
+		return fmt.Errorf("invalid hasher id %q: must be non-empty and not contain ';' or '='", hasher.ID())
+	}
+
+	hasherStore[hasher.ID()] = hasher
+
+	return nil
+}
+
+func validHasherID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, ";=")
+}
+
+// murmur3Sum128 returns the MurmurHash3 (x64_128) hash of data.
+// (Ported from the reference implementation: https://github.com/aappleby/smhasher/blob/master/src/MurmurHash3.cpp)
+func murmur3Sum128(data []byte, seed uint32) (uint64, uint64) {
+	const (
+		c1 = 0x87c37b91114253d5
+		c2 = 0x4cf5ad432745937f
+	)
+
+	h1, h2 := uint64(seed), uint64(seed)
+	length := len(data)
+
+	for ; len(data) >= 16; data = data[16:] {
+		k1 := binary.LittleEndian.Uint64(data)
+		k2 := binary.LittleEndian.Uint64(data[8:])
+
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	var k1, k2 uint64
+
+	// Tail: up to 15 remaining bytes, with the upper 8 going into k2.
+	for i := len(data) - 1; i >= 8; i-- {
+		k2 ^= uint64(data[i]) << (8 * (i - 8))
+	}
+
+	if len(data) > 8 {
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+
+		data = data[:8]
+	}
+
+	for i := len(data) - 1; i >= 0; i-- {
+		k1 ^= uint64(data[i]) << (8 * i)
+	}
+
+	if len(data) > 0 {
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(length)
+	h2 ^= uint64(length)
+
+	h1 += h2
+	h2 += h1
+
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+
+	h1 += h2
+	h2 += h1
+
+	return h1, h2
+}
+
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+
+	return k
+}
+
+// murmur64A returns the MurmurHash64A hash of data.
+// (Ported from the reference implementation: https://github.com/aappleby/smhasher/blob/master/src/MurmurHash2.cpp)
+func murmur64A(data []byte, seed uint64) uint64 {
+	const (
+		mul = 0xc6a4a7935bd1e995
+		r   = 47
+	)
+
+	h := seed ^ (uint64(len(data)) * mul)
+
+	for ; len(data) >= 8; data = data[8:] {
+		k := binary.LittleEndian.Uint64(data)
+
+		k *= mul
+		k ^= k >> r
+		k *= mul
+
+		h ^= k
+		h *= mul
+	}
+
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+
+		h *= mul
+	}
+
+	h ^= h >> r
+	h *= mul
+	h ^= h >> r
+
+	return h
+}
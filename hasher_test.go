@@ -0,0 +1,303 @@
+package hll
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+type hasherTestVector struct {
+	input    string
+	expected uint64
+}
+
+// (Produced by the reference C implementations)
+var hasherTestVectors = map[Hasher][]hasherTestVector{
+	Murmur3Hasher{}: {
+		{"", 0x0000000000000000},
+		{"hello", 0xcbd8a7b341bd9b02},
+		{"The quick brown fox jumps over the lazy dog", 0xe34bbc7bbc071b6c},
+	},
+	Murmur64AHasher{}: {
+		{"", 0xd8dfea6585bc9732},
+		{"hello", 0x0f656f01eecfe400},
+		{"The quick brown fox jumps over the lazy dog", 0x51606c5c5b561ace},
+	},
+}
+
+func TestHashers(t *testing.T) {
+	for hasher, vectors := range hasherTestVectors {
+		for _, vector := range vectors {
+			h := hasher.Hash([]byte(vector.input))
+
+			if h != vector.expected {
+				t.Logf("hasher (%s) - %q expected: %#x, got: %#x", hasher.ID(), vector.input, vector.expected, h)
+				t.Fail()
+			}
+		}
+	}
+}
+
+type testHasher struct{}
+
+func (testHasher) ID() string {
+	return "test"
+}
+
+func (testHasher) Hash(element []byte) uint64 {
+	return Murmur3Hasher{}.Hash(element) ^ 1
+}
+
+type badTestHasher struct{}
+
+func (badTestHasher) ID() string {
+	return "test;bad"
+}
+
+func (badTestHasher) Hash(element []byte) uint64 {
+	return 0
+}
+
+func TestRegisterHasher(t *testing.T) {
+	err := RegisterHasher(testHasher{})
+
+	if err != nil {
+		t.Fatalf("register hasher - errored when adding hasher (shouldn't have): %v", err)
+	}
+
+	if _, exists := hasherStore[testHasher{}.ID()]; !exists {
+		t.Fatalf("register hasher - given hasher doesn't exist in store after registration")
+	}
+}
+
+func TestRegisterHasher_Invalid(t *testing.T) {
+	for _, hasher := range []Hasher{nil, badTestHasher{}} {
+		err := RegisterHasher(hasher)
+
+		if err == nil {
+			t.Logf("register hasher - expected to error when given hasher: %v, but did not", hasher)
+			t.Fail()
+		}
+	}
+}
+
+func createHasherSketch(t *testing.T, hasher Hasher) Sketch {
+	options := DefaultSketchOptions()
+	options.Hasher = hasher
+
+	s, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestSketch_Hasher(t *testing.T) {
+	rand.Seed(0)
+
+	for _, hasher := range []Hasher{XXH3Hasher{}, Murmur3Hasher{}, Murmur64AHasher{}} {
+		s := createHasherSketch(t, hasher)
+
+		for i := 0; i < 100_000; i++ {
+			s.Insert([]byte(genPseudoRandomStr()))
+		}
+
+		if !acceptableEstimate(100_000, s.Estimate()) {
+			t.Logf("sketch hasher (%s) - expected a cardinality +/-3%% of: %d, got: %d", hasher.ID(), 100_000, s.Estimate())
+			t.Fail()
+		}
+	}
+}
+
+func TestSketch_MergeDiffHasher(t *testing.T) {
+	s0 := createHasherSketch(t, XXH3Hasher{})
+	s1 := createHasherSketch(t, Murmur3Hasher{})
+
+	_, err := s0.Merge(s1)
+
+	if err != ErrorMismatchedHasher {
+		t.Logf("sketch merge - expected merge with different hashers to fail with: %v, got: %v", ErrorMismatchedHasher, err)
+		t.Fail()
+	}
+
+	_, err = Rollup([]Sketch{s0, s1})
+
+	if err == nil {
+		t.Logf("rollup - expected rollup to error with different hashers, but did not")
+		t.Fail()
+	}
+}
+
+func TestSketch_HasherProtoRoundTrip(t *testing.T) {
+	err := RegisterHasher(testHasher{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hasher := range []Hasher{XXH3Hasher{}, Murmur64AHasher{}, testHasher{}} {
+		s0 := createHasherSketch(t, hasher)
+		s0.Insert([]byte("test"))
+
+		bs, err := s0.ProtoSerialize()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s1, err := ProtoDeserialize(bs)
+
+		if err != nil {
+			t.Fatalf("sketch hasher proto (%s) - unexpected error deserializing: %v", hasher.ID(), err)
+		}
+
+		if s1.getHasher().ID() != hasher.ID() || s1.getVersion() != s0.getVersion() {
+			t.Logf("sketch hasher proto - expected hasher: %s (version: %s), got: %s (version: %s)", hasher.ID(), s0.getVersion(), s1.getHasher().ID(), s1.getVersion())
+			t.Fail()
+		}
+
+		// The deserialized sketch should hash the same way as the original.
+		s1.Insert([]byte("test"))
+
+		if s1.Estimate() != 1 {
+			t.Logf("sketch hasher proto (%s) - expected re-inserting the same element to give estimate: %d, got: %d", hasher.ID(), 1, s1.Estimate())
+			t.Fail()
+		}
+	}
+}
+
+func TestSketch_HasherProtoUnregistered(t *testing.T) {
+	s := createHasherSketch(t, testHasher{})
+	ps := s.ProtoSketch()
+	ps.Version = currentVersion + protoParamSeparator + protoHasherKey + "=unregistered"
+
+	_, err := FromProtoSketch(ps)
+
+	if !errors.Is(err, ErrorUnknownHasher) {
+		t.Fatalf("from proto sketch - expected unregistered hasher to fail with: %v, got: %v", ErrorUnknownHasher, err)
+	}
+}
+
+func TestNewSketchWithOptions_InvalidHasher(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Hasher = badTestHasher{}
+
+	_, err := NewSketchWithOptions(options)
+
+	if err == nil {
+		t.Fatalf("new sketch with options - expected invalid hasher id to error, but did not")
+	}
+}
+
+func createSeededSketch(t *testing.T, seed uint64) Sketch {
+	options := DefaultSketchOptions()
+	options.Seed = seed
+
+	s, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestSketch_Seed(t *testing.T) {
+	s0 := createSeededSketch(t, 1).(*sketch)
+	s1 := createSeededSketch(t, 2).(*sketch)
+
+	if s0.hash([]byte("test")) == s1.hash([]byte("test")) {
+		t.Fatalf("sketch seed - expected different seeds to produce different hashes, but did not")
+	}
+
+	if s0.hash([]byte("test")) != createSeededSketch(t, 1).(*sketch).hash([]byte("test")) {
+		t.Fatalf("sketch seed - expected the same seed to produce the same hash, but did not")
+	}
+}
+
+func TestSketch_MergeDiffSeed(t *testing.T) {
+	s0 := createSeededSketch(t, 1)
+	s1 := createSeededSketch(t, 2)
+
+	_, err := s0.Merge(s1)
+
+	if err != ErrorMismatchedSeed {
+		t.Logf("sketch merge - expected merge with different seeds to fail with: %v, got: %v", ErrorMismatchedSeed, err)
+		t.Fail()
+	}
+
+	_, err = Rollup([]Sketch{s0, s1})
+
+	if err == nil {
+		t.Logf("rollup - expected rollup to error with different seeds, but did not")
+		t.Fail()
+	}
+}
+
+func TestSketch_SeedProtoRoundTrip(t *testing.T) {
+	s0 := createSeededSketch(t, 0xdeadbeef)
+	s0.Insert([]byte("test"))
+
+	bs, err := s0.ProtoSerialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the fingerprint is serialized, never the seed.
+	if bytes.Contains(bs, []byte("deadbeef")) {
+		t.Fatalf("sketch seed proto - expected seed not to be serialized, got: %q", bs)
+	}
+
+	// So it's needed to deserialize.
+	for _, seed := range []uint64{0, 0xdeadbeee} {
+		if _, err := ProtoDeserializeWithSeed(bs, seed); !errors.Is(err, ErrorMismatchedSeed) {
+			t.Fatalf("sketch seed proto - expected seed: %#x to fail with: %v, got: %v", seed, ErrorMismatchedSeed, err)
+		}
+	}
+
+	s1, err := ProtoDeserializeWithSeed(bs, 0xdeadbeef)
+
+	if err != nil {
+		t.Fatalf("sketch seed proto - unexpected error deserializing: %v", err)
+	}
+
+	if s1.getSeed() != 0xdeadbeef || s1.getSeedFingerprint() != s0.getSeedFingerprint() {
+		t.Fatalf("sketch seed proto - expected seed: %#x (fingerprint: %#x), got: %#x (fingerprint: %#x)", 0xdeadbeef, s0.getSeedFingerprint(), s1.getSeed(), s1.getSeedFingerprint())
+	}
+
+	if _, err := s0.Clone().Merge(s1); err != nil {
+		t.Fatalf("sketch seed proto - unexpected error merging: %v", err)
+	}
+
+	// Re-inserting the same element (hashed with the same seed) changes nothing.
+	s1.Insert([]byte("test"))
+
+	if s1.Estimate() != 1 {
+		t.Logf("sketch seed proto - expected re-inserting the same element to give estimate: %d, got: %d", 1, s1.Estimate())
+		t.Fail()
+	}
+
+	// (Unseeded sketches don't have a seed to supply).
+	bs, _ = NewSketch().ProtoSerialize()
+
+	if _, err := ProtoDeserializeWithSeed(bs, 0xdeadbeef); !errors.Is(err, ErrorMismatchedSeed) {
+		t.Logf("sketch seed proto - expected a seed for an unseeded sketch to fail with: %v, got: %v", ErrorMismatchedSeed, err)
+		t.Fail()
+	}
+}
+
+func TestNewSketchWithOptions_UnseededHasher(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Hasher = Murmur3Hasher{}
+	options.Seed = 1
+
+	_, err := NewSketchWithOptions(options)
+
+	if err == nil {
+		t.Fatalf("new sketch with options - expected seed with an unseeded hasher to error, but did not")
+	}
+}
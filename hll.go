@@ -1,31 +1,44 @@
 package hll
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"math/bits"
+	"strconv"
+	"strings"
+	"unsafe"
 
 	hllProto "github.com/kixa/hll-protobuf"
-	"github.com/zeebo/xxh3"
 	"google.golang.org/protobuf/proto"
 )
 
 const (
 	hashLength = 64
 
-	precision = 14
-	remnant   = hashLength - precision
+	// MinPrecision is the smallest precision accepted by NewSketchWithPrecision (16 registers).
+	MinPrecision = 4
+
+	// MaxPrecision is the largest precision accepted by NewSketchWithPrecision (262,144 registers).
+	MaxPrecision = 18
+
+	// DefaultPrecision is the precision used by NewSketch and NewCustomSketch (16,384 registers).
+	DefaultPrecision = 14
 
 	currentVersion = "1"
 )
 
-var (
-	m  = uint64(math.Pow(2, precision))
-	mf = float64(m)
+// m is the number of registers at DefaultPrecision, which is also the precision that bias sets are generated at.
+var m = uint64(1) << DefaultPrecision
 
-	maxLinearCounting = uint64(11500)
-)
+// Empirically determined thresholds below which linear counting outperforms bias correction, indexed
+// by precision - MinPrecision.
+// (From "HyperLogLog in Practice", Appendix: https://research.google/pubs/pub40671)
+var maxLinearCounting = [...]uint64{
+	10, 20, 40, 80, 220, 400, 900, 1800, 3100, 6500, 11500, 20000, 50000, 120000, 350000,
+}
 
 var (
 	// ErrorMismatchedVersion is returned from a Merge when two sketch versions do not match.
@@ -34,21 +47,75 @@ var (
 	// ErrorMalformedPrecision is returned from a Merge when a sketch is found to have differing precisions (or
 	// is malformed/incorrectly deserialized).
 	ErrorMalformedPrecision = errors.New("sketch precision mismatch")
+
+	// ErrorMismatchedHasher is returned from a Merge when two sketches were created with different Hashers.
+	ErrorMismatchedHasher = errors.New("sketch hasher mismatch")
+
+	// ErrorMismatchedSeed is returned from a Merge when two sketches were created with different seeds.
+	ErrorMismatchedSeed = errors.New("sketch seed mismatch")
+
+	// ErrorMalformedSketch is returned (wrapped, so check with errors.Is) when deserializing a sketch that is
+	// nil, can't be decoded, or has malformed parameters.
+	ErrorMalformedSketch = errors.New("sketch malformed")
+
+	// ErrorMalformedRegister is returned (wrapped, so check with errors.Is) when deserializing a sketch with a
+	// register larger than any hash could set at its precision.
+	ErrorMalformedRegister = errors.New("sketch register out of range")
+
+	// ErrorUnsupportedVersion is returned (wrapped, so check with errors.Is) when deserializing a sketch with a
+	// version this package can't read.
+	ErrorUnsupportedVersion = errors.New("sketch version unsupported")
+
+	// ErrorUnknownHasher is returned (wrapped, so check with errors.Is) when deserializing a sketch whose Hasher
+	// hasn't been registered via RegisterHasher.
+	ErrorUnknownHasher = errors.New("sketch hasher unknown")
 )
 
 // Sketch is an interface that wraps a HyperLogLog implementation for counting unique elements.
+// Sketches returned by NewSketch (and friends) are not safe for concurrent use, see ConcurrentSketch.
 type Sketch interface {
 	// Insert inserts element into the Sketch.
 	Insert(element []byte)
 
-	// Estimate returns an estimate (+/-3%) of the number of uniques (cardinality) of everything that
-	//has been inserted.
+	// InsertString inserts element into the Sketch, without allocating. This is equivalent to
+	// Insert([]byte(element)).
+	InsertString(element string)
+
+	// InsertUint64 inserts element into the Sketch, without allocating. This is equivalent to Insert with the
+	// 8 byte little-endian encoding of element.
+	InsertUint64(element uint64)
+
+	// InsertHash inserts an already hashed element into the Sketch, skipping its Hasher (and seed). h should
+	// be a well-distributed 64-bit hash, and must come from the same hash function as any other Sketch this is
+	// merged with.
+	InsertHash(h uint64)
+
+	// InsertMany inserts each of elements into the Sketch.
+	InsertMany(elements [][]byte)
+
+	// Estimate returns an estimate (+/-3% at DefaultPrecision) of the number of uniques (cardinality) of
+	// everything that has been inserted. See EstimateWithBounds for the expected error of a given estimate.
 	Estimate() uint64
 
+	// EstimateWith returns an estimate of the cardinality calculated using estimator, regardless of the
+	// Estimator the Sketch was created with.
+	EstimateWith(estimator Estimator) uint64
+
+	// EstimateWithBounds returns an estimate of the cardinality, along with the lower and upper bounds of
+	// its confidence interval at the given confidence level (in [0, 1], e.g. 0.95).
+	EstimateWithBounds(confidence float64) (estimate, lower, upper uint64)
+
+	// RelativeStandardError returns the relative standard error of estimates from this Sketch's configuration
+	// (1.04/sqrt(2^precision)). Roughly 68% of estimates fall within one standard error of the true value.
+	RelativeStandardError() float64
+
 	// Merge merges this Sketch with other, returning itself (now combined with other) and an non-nil
 	// error if the Merge could not be completed.
 	Merge(other Sketch) (Sketch, error)
 
+	// Clone returns an independent (deep) copy of this Sketch.
+	Clone() Sketch
+
 	// ProtoSketch returns the protobuf serializable struct representing this Sketch and should only be used for
 	// embedding Sketches into larger protobuf messages. For all other use-cases use ProtoSerialize.
 	// The reference proto format can be found at: https://github.com/kixa/hll-protobuf
@@ -60,92 +127,312 @@ type Sketch interface {
 	ProtoSerialize() ([]byte, error)
 
 	getRegisters() []uint8
+	getPrecision() uint8
+	getHasher() Hasher
+	getSeed() uint64
+	getSeedFingerprint() uint64
 	getVersion() string
+	emptyCopy() *sketch
 }
 
 type sketch struct {
 	biasSet   *biases
-	registers []uint8
+	registers registerArray
+	encoding  RegisterEncoding
+
+	// sparse holds registers until enough are touched that it's worth allocating registers (at which
+	// point it's set to nil). It is always nil for sketches not created as sparse.
+	sparse *sparseRegisters
+
+	// counts caches histogram() for dense registers, so estimates don't need to scan every register. It's kept
+	// up to date by addHash and Merge, and is nil until first needed (or after converting from sparse).
+	counts []int
+
+	hasher Hasher
+
+	// seed is 0 if s is unseeded. seedFingerprint identifies the seed (see seedFingerprint), and is what's
+	// serialized and compared by merges, so seeds never leave the process.
+	seed            uint64
+	seedFingerprint uint64
 
-	version string
+	estimator Estimator
+	precision uint8
+	version   string
+
+	// buf is scratch space for InsertUint64, so the encoded element doesn't escape to the heap when handed to
+	// the Hasher.
+	buf [8]byte
 }
 
 // Insert inserts element into the Sketch.
 func (s *sketch) Insert(element []byte) {
-	h := xxh3.Hash(element)
+	h := s.hash(element)
 	s.addHash(h)
 }
 
-func (s *sketch) addHash(h uint64) {
-	register, zeros := getRegisterAndLeadingZeros(h)
+// InsertString inserts element into the Sketch, without allocating.
+func (s *sketch) InsertString(element string) {
+	s.addHash(s.hash(stringBytes(element)))
+}
+
+// InsertUint64 inserts the 8 byte little-endian encoding of element into the Sketch, without allocating.
+func (s *sketch) InsertUint64(element uint64) {
+	binary.LittleEndian.PutUint64(s.buf[:], element)
+	s.addHash(s.hash(s.buf[:]))
+}
+
+// InsertHash inserts an already hashed element into the Sketch.
+func (s *sketch) InsertHash(h uint64) {
+	s.addHash(h)
+}
+
+// InsertMany inserts each of elements into the Sketch.
+func (s *sketch) InsertMany(elements [][]byte) {
+	if s.seed != 0 {
+		hasher := s.hasher.(SeededHasher)
+
+		for _, element := range elements {
+			s.addHash(hasher.HashSeed(element, s.seed))
+		}
+
+		return
+	}
+
+	for _, element := range elements {
+		s.addHash(s.hasher.Hash(element))
+	}
+}
+
+// stringBytes returns the bytes of str without copying them. The result must not be modified (Hashers never
+// modify or retain what they're given).
+func stringBytes(str string) []byte {
+	return *(*[]byte)(unsafe.Pointer(&struct {
+		string
+		int
+	}{str, len(str)}))
+}
+
+// hash returns the hash of element using the hasher (and seed, if any) of s.
+func (s *sketch) hash(element []byte) uint64 {
+	if s.seed != 0 {
+		return s.hasher.(SeededHasher).HashSeed(element, s.seed)
+	}
+
+	return s.hasher.Hash(element)
+}
+
+// setSeed sets the seed of s (which must use a SeededHasher, if seed is non-zero), along with its fingerprint.
+func (s *sketch) setSeed(seed uint64) {
+	s.seed = seed
+	s.seedFingerprint = seedFingerprint(seed)
+}
+
+// setDeserializedSeed sets the seed of a deserialized s, which only carries the fingerprint of its seed. An error
+// wrapping ErrorMismatchedSeed is returned if seed isn't the one s was created with (or 0, if it's unseeded).
+func (s *sketch) setDeserializedSeed(seed uint64) error {
+	if seedFingerprint(seed) != s.seedFingerprint {
+		if seed == 0 {
+			return fmt.Errorf("cannot deserialize seeded sketch without its seed: %w", ErrorMismatchedSeed)
+		}
+
+		return fmt.Errorf("cannot deserialize sketch with a seed it wasn't created with: %w", ErrorMismatchedSeed)
+	}
+
+	s.seed = seed
+
+	return nil
+}
+
+// seedFingerprint returns a one-way fingerprint of seed (or 0 for unseeded sketches), which is carried in
+// serialized sketches in place of the seed. This is enough to check two sketches used the same seed, without
+// letting anyone who receives a sketch recover the seed (and test its registers for known elements).
+func seedFingerprint(seed uint64) uint64 {
+	if seed == 0 {
+		return 0
+	}
+
+	var bs [len(seedFingerprintDomain) + 8]byte
+
+	copy(bs[:], seedFingerprintDomain)
+	binary.LittleEndian.PutUint64(bs[len(seedFingerprintDomain):], seed)
+
+	sum := sha256.Sum256(bs[:])
+
+	// (0 is reserved for unseeded sketches).
+	return binary.LittleEndian.Uint64(sum[:8]) | 1
+}
+
+// seedFingerprintDomain prefixes seeds when fingerprinting them, so fingerprints can't be matched against
+// hashes of seeds used elsewhere.
+const seedFingerprintDomain = "hll-go seed fingerprint\x00"
+
+// addHash inserts h, returning whether this may have changed the registers (sparse inserts are buffered, so
+// always may have).
+func (s *sketch) addHash(h uint64) bool {
+	register, zeros := getRegisterAndLeadingZeros(h, s.precision)
 
 	// Avoid 0's for the harmonic mean...
 	// (As in: 1/0 is sadtimes, so we need to know whether to include this or not in estimate calculation).
 	zeros += 1
 
-	if s.registers[register] >= zeros {
-		return
+	if s.sparse != nil {
+		s.sparse.insert(register, zeros)
+
+		if s.sparse.size() > s.maxSparseSize() {
+			// (Buffered inserts may just be duplicates, so only convert if they are still too big once flushed).
+			s.sparse.flush()
+
+			if s.sparse.size() > s.maxSparseSize() {
+				s.toDense()
+			}
+		}
+
+		return true
 	}
 
-	s.registers[register] = zeros
+	return s.raise(register, zeros)
 }
 
-// (This translates to 14 0's, followed by 50 1's)
-const bitMask = (1 << remnant) - 1
+// raise sets the (dense) register to v if that's larger than its current value, keeping counts up to date, and
+// returns whether it did.
+func (s *sketch) raise(register uint64, v uint8) bool {
+	previous := s.registers.get(register)
+
+	if previous >= v {
+		return false
+	}
+
+	s.registers.set(register, v)
 
-// getRegisterAndLeadingZeros returns the register to inc (bits [0..14]) and
-// the number of leading zeros in the remnant [14..].
+	if s.counts != nil {
+		s.counts[previous] -= 1
+		s.counts[v] += 1
+	}
+
+	return true
+}
+
+// getRegisterAndLeadingZeros returns the register to inc (bits [0..precision]) and
+// the number of leading zeros in the remnant [precision..].
 //
 // Assumes: Most Significant Bit (MSB) is at index 0.
 //
 // Bit Tricks:
-// - First 14 found by right shifting the length of remnant (50).
+// - First precision bits found by right shifting the length of remnant (64 - precision).
 // - Remnant found by using bitMask and logical 'and', i.e: 0 and X = 0, 1 and X = X.
-func getRegisterAndLeadingZeros(hash uint64) (uint64, uint8) {
-	return hash >> remnant, uint8(bits.LeadingZeros(uint(hash&bitMask)) - precision)
+//
+// (At precision 14, bitMask translates to 14 0's, followed by 50 1's)
+func getRegisterAndLeadingZeros(hash uint64, precision uint8) (uint64, uint8) {
+	remnant := hashLength - precision
+	bitMask := uint64(1)<<remnant - 1
+
+	return hash >> remnant, uint8(bits.LeadingZeros64(hash&bitMask)) - precision
+}
+
+// maxRank is the largest rank (register value) at any precision (see maxRankAt).
+const maxRank = hashLength - MinPrecision + 1
+
+// maxRankAt returns the largest rank any hash can give at precision: a remnant of all 0's, plus 1.
+func maxRankAt(precision uint8) uint8 {
+	return hashLength - precision + 1
 }
 
 // Estimate returns the estimated cardinality (number of unique items) inserted into this Sketch.
 // It is accurate to +/-3% of the 'true' value, however in practice, it performs significantly better than that.
+//
+// Dense Sketches keep a histogram of their registers up to date as they change, so estimates don't depend on the
+// number of registers. Sparse Sketches don't: each estimate (flushes buffered inserts, then) counts every touched
+// register, which is at most a few thousand at the default precision before the Sketch converts to dense.
 func (s *sketch) Estimate() uint64 {
+	return s.EstimateWith(s.estimator)
+}
+
+// EstimateWith returns the estimated cardinality inserted into this Sketch, calculated using estimator.
+// Unknown estimators fall back to EstimatorBiasCorrected.
+func (s *sketch) EstimateWith(estimator Estimator) uint64 {
+	estimate, _ := s.estimate(estimator)
+	return estimate
+}
+
+// estimate returns the estimated cardinality using estimator, along with the branch that produced it.
+func (s *sketch) estimate(estimator Estimator) (uint64, estimateBranch) {
+	switch estimator {
+	case EstimatorErtl:
+		return s.estimateBranchOf(s.ertlEstimate())
+	case EstimatorMLE:
+		return s.estimateBranchOf(s.mleEstimate())
+	}
+
+	return s.biasCorrectedEstimate()
+}
+
+// biasCorrectedEstimate returns the HLL++ style estimate (see EstimatorBiasCorrected).
+func (s *sketch) biasCorrectedEstimate() (uint64, estimateBranch) {
 	rawEstimate := s.rawHarmonicEstimate()
 
+	// Bias sets are generated at DefaultPrecision, so scale rawEstimate into the same range before any
+	// lookups. (The bias is a function of the load factor (estimate / registers), not the estimate itself). They
+	// are also generated from estimates using alpha, so undo the smaller alphaAt of low precisions.
+	biasEstimate := uint64(float64(rawEstimate) * alpha / alphaAt(s.registerCount()) * float64(m) / float64(s.registerCount()))
+
 	// Bigger than largest elem in bias set, just use raw.
-	if rawEstimate > s.biasSet.maxTick {
-		return rawEstimate
+	if biasEstimate > s.biasSet.maxTick {
+		return rawEstimate, branchRaw
 	}
 
-	// Less than 11,500, use LinearCount.
-	if rawEstimate < maxLinearCounting {
-		return s.linearCounting()
+	// Less than the threshold for this precision (11,500 at DefaultPrecision), use LinearCount.
+	if rawEstimate < maxLinearCounting[s.precision-MinPrecision] {
+		return s.linearCounting(), branchLinearCounting
 	}
 
 	// Anything else, return interpolated bias.
-	return uint64(s.biasSet.getInterpolatedBias(int(rawEstimate)) * float64(rawEstimate))
+	return uint64(s.biasSet.getInterpolatedBias(int(biasEstimate)) * float64(rawEstimate)), branchBiasCorrected
 }
 
 // This is a 'predictable' bias correction constant.
 // tl;dr: http://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf
 var alpha = 1 / (2 * math.Log(2))
 
+// alphaAt returns the bias correction constant for registerCount registers. alpha is its limit as the number of
+// registers grows, which is close enough from 128 registers up, but overestimates by up to 7% below that.
+func alphaAt(registerCount int) float64 {
+	switch registerCount {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+
+	return alpha
+}
+
+// inversePowers holds 2^(-1*n) for every register value n (up to maxRank), so harmonic sums don't need math.Pow.
+var inversePowers = func() (powers [maxRank + 1]float64) {
+	for n := range powers {
+		powers[n] = math.Ldexp(1, -n)
+	}
+
+	return powers
+}()
+
 // rawHarmonicEstimate returns a harmonic average across each registers raw estimate.
 func (s *sketch) rawHarmonicEstimate() uint64 {
-	var sum float64
-	var registersUsed float64
-
-	for _, n := range s.registers {
-		// Don't count any registers that haven't been touched.
-		if n <= 0 {
-			continue
-		}
+	counts := s.histogram()
 
-		registersUsed += 1
+	var sum float64
 
-		// Classic estimate of cardinality is: 2^n (where n is number of leading 0's).
-		// However, since we want the reciprocal for the harmonic case, we use 2^(-1*n)
-		sum += math.Pow(2, float64(n)*-1)
+	// Classic estimate of cardinality is: 2^n (where n is number of leading 0's).
+	// However, since we want the reciprocal for the harmonic case, we use 2^(-1*n), for each of the counts[n]
+	// registers holding n. (Smallest first, for accuracy).
+	for n := len(counts) - 1; n >= 1; n-- {
+		sum += float64(counts[n]) * inversePowers[n]
 	}
 
+	// Don't count any registers that haven't been touched.
+	registersUsed := float64(s.registerCount() - counts[0])
+
 	// Special case: No registers used; nothing added, so no cardinality.
 	if registersUsed <= 0 {
 		return 0
@@ -153,57 +440,128 @@ func (s *sketch) rawHarmonicEstimate() uint64 {
 
 	// Bias corrected calculation is: alpha * registersUsed^2 * (sum^-1), but we can re-write that as
 	// (alpha * registersUsed^2) / sum.
-	return uint64((alpha * registersUsed * registersUsed) / sum)
+	estimate := (alphaAt(s.registerCount()) * registersUsed * registersUsed) / sum
+
+	// (Saturated registers can estimate more than a uint64 holds, and converting that is platform dependent).
+	if estimate >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+
+	return uint64(estimate)
 }
 
 func (s *sketch) linearCounting() uint64 {
-	var registersUsed float64
+	registersUnused := float64(s.histogram()[0])
+	mf := float64(s.registerCount())
 
-	for _, n := range s.registers {
-		if n <= 0 {
-			registersUsed += 1
-		}
-	}
-
-	return uint64(mf * math.Log(mf/registersUsed))
+	return uint64(mf * math.Log(mf/registersUnused))
 }
 
 // Merge merges s with other, returning s for convenience. It will error if there is a version
 // mismatch, or either Sketch's underlying registers are incompatible.
 func (s *sketch) Merge(other Sketch) (Sketch, error) {
-	if s.version != other.getVersion() {
-		return nil, ErrorMismatchedVersion
+	if err := checkMergeable(s, other); err != nil {
+		return nil, err
+	}
+
+	otherSparse := sparseOf(other)
+
+	// Sparse into sparse stays sparse (until it grows too large), anything else needs to be dense.
+	if s.sparse != nil && otherSparse != nil {
+		s.sparse.merge(otherSparse)
+
+		if s.sparse.size() > s.maxSparseSize() {
+			s.toDense()
+		}
+
+		return s, nil
+	}
+
+	if s.sparse != nil {
+		s.toDense()
+	}
+
+	if otherSparse != nil {
+		otherSparse.forEach(func(register uint32, rank uint8) {
+			s.raise(uint64(register), rank)
+		})
+
+		return s, nil
 	}
 
 	otherRegisters := other.getRegisters()
 
-	if len(s.registers) != len(otherRegisters) {
+	if s.registers.count() != len(otherRegisters) {
 		return nil, ErrorMalformedPrecision
 	}
 
-	for i, thisZeros := range s.registers {
-		otherZeros := otherRegisters[i]
-
-		if otherZeros > thisZeros {
-			s.registers[i] = otherZeros
-		}
+	if s.counts != nil {
+		mergeBytesCounted(s.registers, otherRegisters, s.counts)
+	} else {
+		mergeBytes(s.registers, otherRegisters)
 	}
 
 	return s, nil
 }
 
+// Clone returns a deep copy of s, which can be inserted into or merged without affecting s.
+func (s *sketch) Clone() Sketch {
+	c := *s
+
+	if s.registers != nil {
+		c.registers = s.registers.clone()
+	}
+
+	if s.sparse != nil {
+		c.sparse = s.sparse.clone()
+	}
+
+	if s.counts != nil {
+		c.counts = append([]int(nil), s.counts...)
+	}
+
+	return &c
+}
+
+// checkMergeable returns the error from merging a and b, if they are incompatible.
+func checkMergeable(a, b Sketch) error {
+	if a.getVersion() != b.getVersion() {
+		return ErrorMismatchedVersion
+	}
+
+	if a.getPrecision() != b.getPrecision() {
+		return ErrorMalformedPrecision
+	}
+
+	if a.getHasher().ID() != b.getHasher().ID() {
+		return ErrorMismatchedHasher
+	}
+
+	if a.getSeedFingerprint() != b.getSeedFingerprint() {
+		return ErrorMismatchedSeed
+	}
+
+	return nil
+}
+
 // ProtoSketch returns a protobuf compatible version of this Sketch.
 // NOTE: This should only be used for embedding a sketch into a larger protobuf message, all other
 // serialization should use ProtoSerialize.
 func (s *sketch) ProtoSketch() *hllProto.Sketch {
-	registerspb := make([]uint32, len(s.registers))
+	registerspb := make([]uint32, s.registerCount())
 
-	for i, r := range s.registers {
-		registerspb[i] = uint32(r)
+	if s.sparse != nil {
+		s.sparse.forEach(func(register uint32, rank uint8) {
+			registerspb[register] = uint32(rank)
+		})
+	}
+
+	for i := 0; s.registers != nil && i < s.registers.count(); i++ {
+		registerspb[i] = uint32(s.registers.get(uint64(i)))
 	}
 
 	return &hllProto.Sketch{
-		Version:   s.version,
+		Version:   s.protoVersion(),
 		Registers: registerspb,
 	}
 }
@@ -214,19 +572,135 @@ func (s *sketch) ProtoSerialize() ([]byte, error) {
 	return proto.Marshal(s.ProtoSketch())
 }
 
+// getRegisters returns the dense registers for s. For sparse or packed (non-Encoding8Bit) sketches this is a
+// (new) materialised copy, so writes to it are not reflected in s.
 func (s *sketch) getRegisters() []uint8 {
-	return s.registers
+	if s.sparse != nil {
+		registers := make(byteRegisters, s.registerCount())
+		s.sparse.toDense(registers)
+
+		return registers
+	}
+
+	return toBytes(s.registers)
+}
+
+func (s *sketch) getPrecision() uint8 {
+	return s.precision
+}
+
+func (s *sketch) getHasher() Hasher {
+	return s.hasher
+}
+
+func (s *sketch) getSeed() uint64 {
+	return s.seed
+}
+
+func (s *sketch) getSeedFingerprint() uint64 {
+	return s.seedFingerprint
 }
 
 func (s *sketch) getVersion() string {
 	return s.version
 }
 
-// NewSketch returns a new Sketch using the default biases.
+// registerCount returns the number of registers (m) in s, whether or not they have been allocated.
+func (s *sketch) registerCount() int {
+	return 1 << s.precision
+}
+
+// maxSparseSize returns the size (in bytes) past which a sparse s is converted to dense registers.
+// This is a quarter of the size of the dense registers, since past this point sparse inserts start to
+// get expensive for relatively little saving.
+func (s *sketch) maxSparseSize() int {
+	return registerArraySize(s.encoding, s.registerCount()) / 4
+}
+
+// size returns the (approximate) number of bytes used to hold the registers of s.
+func (s *sketch) size() int {
+	if s.sparse != nil {
+		return s.sparse.size()
+	}
+
+	return s.registers.size()
+}
+
+// toDense converts a sparse s to use dense registers.
+func (s *sketch) toDense() {
+	s.registers = newRegisterArray(s.encoding, s.registerCount())
+	s.sparse.toDense(s.registers)
+	s.sparse = nil
+	s.counts = nil
+}
+
+// sparseOf returns the sparse registers of sk, or nil if sk is dense.
+func sparseOf(sk Sketch) *sparseRegisters {
+	if s, ok := sk.(*sketch); ok {
+		return s.sparse
+	}
+
+	return nil
+}
+
+// SketchOptions contains parameters used for NewSketchWithOptions.
+type SketchOptions struct {
+	// Precision sets the number of registers (2^Precision) and must be within [MinPrecision, MaxPrecision].
+	// If 0, DefaultPrecision is used.
+	Precision uint8
+
+	// BiasKey selects biases previously registered via RegisterBiases. If empty, the default biases are used.
+	BiasKey string
+
+	// Sparse starts the Sketch with a sparse representation, which only stores touched registers. This uses
+	// significantly less memory for low cardinalities, and is transparently converted to the standard (dense)
+	// representation once it would no longer save much.
+	Sparse bool
+
+	// Encoding selects how (dense) registers are stored in memory. Defaults to Encoding8Bit.
+	Encoding RegisterEncoding
+
+	// Hasher is used to hash inserted elements. If nil, XXH3Hasher is used. Custom Hashers should also be
+	// registered via RegisterHasher for Sketches using them to be deserialized.
+	Hasher Hasher
+
+	// Seed salts the hash of every inserted element, so registers can't be tested for the presence of
+	// known elements without also knowing the seed. Sketches can only be merged with Sketches using the
+	// same seed. If 0, elements are not seeded. A non-zero seed requires Hasher to be a SeededHasher.
+	//
+	// Serialized Sketches only carry a one-way fingerprint of the seed, so must be deserialized with the seed
+	// again (e.g. via ProtoDeserializeWithSeed), which is checked against the fingerprint. Seeds should be
+	// random: a guessable seed can be recovered from its fingerprint.
+	Seed uint64
+
+	// Estimator selects how estimates are calculated. Defaults to EstimatorBiasCorrected. Like biases, this
+	// is not carried in serialized Sketches.
+	Estimator Estimator
+}
+
+// DefaultSketchOptions returns a copy of the default SketchOptions, which are used by NewSketch.
+func DefaultSketchOptions() *SketchOptions {
+	return &SketchOptions{
+		Precision: DefaultPrecision,
+	}
+}
+
+// NewSketch returns a new Sketch using the default biases and DefaultPrecision.
 func NewSketch() Sketch {
 	return createSketch()
 }
 
+// NewSketchWithPrecision returns a new Sketch using the default biases with 2^precision registers. Lower
+// precisions use less memory at the cost of accuracy (the standard error is roughly 1.04/sqrt(2^precision)).
+// An error is returned if precision is outside of [MinPrecision, MaxPrecision].
+func NewSketchWithPrecision(precision uint8) (Sketch, error) {
+	if !validPrecision(precision) {
+		return nil, fmt.Errorf("invalid precision %d: must be between %d and %d", precision, MinPrecision, MaxPrecision)
+	}
+
+	return createSketchWithPrecision(precision), nil
+}
+
 // NewCustomSketch returns a new Sketch using the biases registered under biasKey. If these biases are not
 // found (previously registered via RegisterBiases), an error will be returned.
 func NewCustomSketch(biasKey string) (Sketch, error) {
@@ -242,20 +716,137 @@ func NewCustomSketch(biasKey string) (Sketch, error) {
 	return s, nil
 }
 
+// NewSketchWithOptions returns a new Sketch configured by options, or DefaultSketchOptions() if options
+// is nil. An error is returned if options are invalid, or refer to biases that have not been registered.
+func NewSketchWithOptions(options *SketchOptions) (Sketch, error) {
+	if options == nil {
+		options = DefaultSketchOptions()
+	}
+
+	precision := options.Precision
+
+	if precision == 0 {
+		precision = DefaultPrecision
+	}
+
+	if !validPrecision(precision) {
+		return nil, fmt.Errorf("invalid options: precision %d must be between %d and %d", precision, MinPrecision, MaxPrecision)
+	}
+
+	if !options.Encoding.valid() {
+		return nil, fmt.Errorf("invalid options: unknown register encoding %v", options.Encoding)
+	}
+
+	if !options.Estimator.valid() {
+		return nil, fmt.Errorf("invalid options: unknown estimator %v", options.Estimator)
+	}
+
+	s := createSketchWithPrecision(precision)
+
+	if options.BiasKey != "" {
+		bs, exist := biasStore[options.BiasKey]
+
+		if !exist {
+			return nil, fmt.Errorf("requested biases %s were not found - they may not have not been registered", options.BiasKey)
+		}
+
+		s.biasSet = bs
+	}
+
+	if options.Hasher != nil {
+		if !validHasherID(options.Hasher.ID()) {
+			return nil, fmt.Errorf("invalid options: hasher id %q must be non-empty and not contain ';' or '='", options.Hasher.ID())
+		}
+
+		s.hasher = options.Hasher
+	}
+
+	if options.Seed != 0 {
+		if _, ok := s.hasher.(SeededHasher); !ok {
+			return nil, fmt.Errorf("invalid options: hasher %s does not support seeds", s.hasher.ID())
+		}
+
+		s.setSeed(options.Seed)
+	}
+
+	s.encoding = options.Encoding
+	s.estimator = options.Estimator
+
+	if options.Sparse {
+		s.registers = nil
+		s.sparse = newSparseRegisters()
+	} else if s.encoding != Encoding8Bit {
+		s.registers = newRegisterArray(s.encoding, s.registerCount())
+	}
+
+	return s, nil
+}
+
+// newConfigSketch returns a sketch configured by options (see NewSketchWithOptions), without dense registers.
+// Sketches built from many sketches (e.g. ShardedSketch) keep one to carry their configuration, and create each
+// of their sketches from it via emptyCopy.
+func newConfigSketch(options *SketchOptions) (*sketch, error) {
+	s, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.(*sketch).withoutRegisters(), nil
+}
+
+// withoutRegisters drops the dense registers of s (which is only kept for its configuration), returning s.
+func (s *sketch) withoutRegisters() *sketch {
+	s.registers = nil
+	s.counts = nil
+
+	return s
+}
+
 func createSketch() *sketch {
+	return createSketchWithPrecision(DefaultPrecision)
+}
+
+func createSketchWithPrecision(precision uint8) *sketch {
 	return &sketch{
 		biasSet:   defaultBiases,
-		registers: make([]uint8, m),
+		registers: make(byteRegisters, 1<<precision),
 
-		version: currentVersion,
+		hasher:    defaultHasher,
+		precision: precision,
+		version:   currentVersion,
 	}
 }
 
+func validPrecision(precision uint8) bool {
+	return precision >= MinPrecision && precision <= MaxPrecision
+}
+
+// precisionFromRegisters returns the precision for a register count of n, or false if n is not a power of
+// 2 within [MinPrecision, MaxPrecision].
+func precisionFromRegisters(n int) (uint8, bool) {
+	if n <= 0 || n&(n-1) != 0 {
+		return 0, false
+	}
+
+	precision := uint8(bits.TrailingZeros(uint(n)))
+
+	return precision, validPrecision(precision)
+}
+
 // ProtoDeserialize returns a Sketch from an encoded protobuf version. The proto schema used can be
 // found in the companion repository: https://github.com/kixa/hll-protobuf
+//
+// Seeded Sketches can't be deserialized without their seed (see ProtoDeserializeWithSeed).
 func ProtoDeserialize(protoBs []byte) (Sketch, error) {
+	return ProtoDeserializeWithSeed(protoBs, 0)
+}
+
+// ProtoDeserializeWithSeed returns a Sketch from an encoded protobuf version, as ProtoDeserialize, which was
+// created with seed (see SketchOptions.Seed). ErrorMismatchedSeed is returned if it wasn't.
+func ProtoDeserializeWithSeed(protoBs []byte, seed uint64) (Sketch, error) {
 	if protoBs == nil {
-		return nil, fmt.Errorf("cannot deserialize nil proto")
+		return nil, fmt.Errorf("cannot deserialize nil proto: %w", ErrorMalformedSketch)
 	}
 
 	var sketchpb hllProto.Sketch
@@ -263,29 +854,152 @@ func ProtoDeserialize(protoBs []byte) (Sketch, error) {
 	err := proto.Unmarshal(protoBs, &sketchpb)
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot deserialize proto (%v): %w", err, ErrorMalformedSketch)
 	}
 
-	return FromProtoSketch(&sketchpb)
+	return FromProtoSketchWithSeed(&sketchpb, seed)
 }
 
 // FromProtoSketch returns a Sketch from a generated protobuf type. The proto schema used can be
 // found in the companion repository: https://github.com/kixa/hll-protobuf
+//
+// Sketches are validated as they're deserialized, so this is safe to use on untrusted input. An error is
+// returned if the number of registers isn't a valid precision (ErrorMalformedPrecision), any register is out of
+// range for it (ErrorMalformedRegister), the version is unsupported (ErrorUnsupportedVersion), its Hasher isn't
+// registered (ErrorUnknownHasher), its parameters are malformed (ErrorMalformedSketch) or it's seeded
+// (ErrorMismatchedSeed, see FromProtoSketchWithSeed).
 func FromProtoSketch(sketch *hllProto.Sketch) (Sketch, error) {
+	return FromProtoSketchWithSeed(sketch, 0)
+}
+
+// FromProtoSketchWithSeed returns a Sketch from a generated protobuf type, as FromProtoSketch, which was created
+// with seed (see SketchOptions.Seed). ErrorMismatchedSeed is returned if it wasn't.
+func FromProtoSketchWithSeed(sketch *hllProto.Sketch, seed uint64) (Sketch, error) {
 	if sketch == nil {
-		return nil, fmt.Errorf("cannot deserialize nil sketch")
+		return nil, fmt.Errorf("cannot deserialize nil sketch: %w", ErrorMalformedSketch)
 	}
 
-	if len(sketch.Registers) <= 0 {
+	// Precision isn't part of the proto, so it's inferred from the number of registers.
+	precision, ok := precisionFromRegisters(len(sketch.Registers))
+
+	if !ok {
 		return nil, ErrorMalformedPrecision
 	}
 
-	s := createSketch()
-	s.version = sketch.Version
+	s := createSketchWithPrecision(precision)
+	err := s.setProtoVersion(sketch.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.setDeserializedSeed(seed); err != nil {
+		return nil, err
+	}
+
+	largest := uint32(maxRankAt(precision))
 
 	for i, registerpb := range sketch.Registers {
-		s.registers[i] = uint8(registerpb)
+		if registerpb > largest {
+			return nil, fmt.Errorf("cannot deserialize sketch with register %d of %d (precision %d allows at most %d): %w", i, registerpb, precision, largest, ErrorMalformedRegister)
+		}
+
+		if registerpb > 0 {
+			s.registers.set(uint64(i), uint8(registerpb))
+		}
 	}
 
 	return s, nil
 }
+
+// The proto only has a version field, so any non-default parameters needed to merge a deserialized sketch
+// are appended to the version as: <version>;<key>=<value>... (Default sketches only use the version, so
+// remain readable by other implementations, and anything else will fail their version checks).
+const (
+	protoParamSeparator = ";"
+	protoHasherKey      = "hash"
+	protoSeedKey        = "seedfp"
+)
+
+// protoVersion returns the version field to use when serializing s to proto.
+func (s *sketch) protoVersion() string {
+	version := s.version
+
+	if s.hasher.ID() != defaultHasher.ID() {
+		version += protoParamSeparator + protoHasherKey + "=" + s.hasher.ID()
+	}
+
+	if s.seedFingerprint != 0 {
+		version += protoParamSeparator + protoSeedKey + "=" + strconv.FormatUint(s.seedFingerprint, 16)
+	}
+
+	return version
+}
+
+// setProtoVersion sets the version of s, and any parameters, from the version field of a serialized proto.
+func (s *sketch) setProtoVersion(protoVersion string) error {
+	params := strings.Split(protoVersion, protoParamSeparator)
+
+	if params[0] != currentVersion {
+		return fmt.Errorf("cannot deserialize sketch with version %q (expected: %q): %w", params[0], currentVersion, ErrorUnsupportedVersion)
+	}
+
+	s.version = params[0]
+
+	for _, param := range params[1:] {
+		kv := strings.SplitN(param, "=", 2)
+
+		if len(kv) != 2 {
+			return fmt.Errorf("cannot deserialize sketch with malformed parameter %q: %w", param, ErrorMalformedSketch)
+		}
+
+		switch kv[0] {
+		case protoHasherKey:
+			hasher, exist := hasherStore[kv[1]]
+
+			if !exist {
+				return fmt.Errorf("requested hasher %s was not found - it may not have been registered: %w", kv[1], ErrorUnknownHasher)
+			}
+
+			s.hasher = hasher
+		case protoSeedKey:
+			fingerprint, err := strconv.ParseUint(kv[1], 16, 64)
+
+			if err != nil || fingerprint == 0 {
+				return fmt.Errorf("cannot deserialize sketch with malformed seed fingerprint %q: %w", kv[1], ErrorMalformedSketch)
+			}
+
+			s.seedFingerprint = fingerprint
+		default:
+			return fmt.Errorf("cannot deserialize sketch with unknown parameter %q: %w", param, ErrorMalformedSketch)
+		}
+	}
+
+	if _, ok := s.hasher.(SeededHasher); s.seedFingerprint != 0 && !ok {
+		return fmt.Errorf("cannot deserialize seeded sketch (hasher %s does not support seeds): %w", s.hasher.ID(), ErrorMalformedSketch)
+	}
+
+	return nil
+}
+
+// emptyCopy returns a new, empty sketch with the same configuration as s.
+func (s *sketch) emptyCopy() *sketch {
+	c := createSketchWithPrecision(s.precision)
+
+	c.biasSet = s.biasSet
+	c.encoding = s.encoding
+	c.hasher = s.hasher
+	c.seed = s.seed
+	c.seedFingerprint = s.seedFingerprint
+	c.estimator = s.estimator
+	c.version = s.version
+
+	if s.sparse != nil {
+		c.registers = nil
+		c.sparse = newSparseRegisters()
+	} else if c.encoding != Encoding8Bit {
+		c.registers = newRegisterArray(c.encoding, c.registerCount())
+	}
+
+	return c
+}
@@ -1,14 +1,20 @@
 package hll
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"math/bits"
 	"math/rand"
 	"strconv"
 	"testing"
+	"time"
 
 	hllProto "github.com/kixa/hll-protobuf"
 	"github.com/zeebo/xxh3"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -48,7 +54,7 @@ func TestSketch_Insert(t *testing.T) {
 	// One register should be filled.
 	regFillCount := 0
 
-	for _, c := range s.registers {
+	for _, c := range s.getRegisters() {
 		if c > 0 {
 			regFillCount += 1
 		}
@@ -60,45 +66,119 @@ func TestSketch_Insert(t *testing.T) {
 	}
 }
 
+func TestSketch_InsertTyped(t *testing.T) {
+	rand.Seed(0)
+
+	for _, seed := range []uint64{0, 1} {
+		expected := createSeededSketch(t, seed)
+		s := createSeededSketch(t, seed)
+
+		elements := make([][]byte, 0, 1_000)
+
+		for i := 0; i < 1_000; i++ {
+			str := genPseudoRandomStr()
+			n := rand.Uint64()
+
+			bs := make([]byte, 8)
+			binary.LittleEndian.PutUint64(bs, n)
+
+			expected.Insert([]byte(str))
+			expected.Insert(bs)
+			s.InsertString(str)
+			s.InsertUint64(n)
+
+			elements = append(elements, []byte(genPseudoRandomStr()))
+		}
+
+		for _, element := range elements {
+			expected.Insert(element)
+		}
+
+		s.InsertMany(elements)
+
+		registers := s.getRegisters()
+
+		for i, r := range expected.getRegisters() {
+			if registers[i] != r {
+				t.Fatalf("sketch typed insert (seed: %d) - register %d expected: %d, got: %d", seed, i, r, registers[i])
+			}
+		}
+	}
+}
+
+func TestSketch_InsertHash(t *testing.T) {
+	s0 := createSketch()
+	s1 := createSketch()
+
+	s0.Insert([]byte("test"))
+	s1.InsertHash(XXH3Hasher{}.Hash([]byte("test")))
+
+	registers := s1.getRegisters()
+
+	for i, r := range s0.getRegisters() {
+		if registers[i] != r {
+			t.Fatalf("sketch insert hash - register %d expected: %d, got: %d", i, r, registers[i])
+		}
+	}
+}
+
+func TestSketch_InsertTypedAllocs(t *testing.T) {
+	s := createSketch()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.InsertString("test")
+		s.InsertUint64(42)
+		s.InsertHash(42)
+	})
+
+	if allocs != 0 {
+		t.Logf("sketch typed insert - expected: %d allocations, got: %v", 0, allocs)
+		t.Fail()
+	}
+}
+
 func TestGetRegisterAndLeadingZeros(t *testing.T) {
 	rand.Seed(0)
 
 	for i := 0; i < 100; i++ {
 		v := xxh3.Hash([]byte(genPseudoRandomStr()))
-		runGetRegisterAndLeadingZeros(v, t)
+
+		for p := uint8(MinPrecision); p <= MaxPrecision; p++ {
+			runGetRegisterAndLeadingZeros(v, p, t)
+		}
 	}
 }
 
-func runGetRegisterAndLeadingZeros(v uint64, t *testing.T) {
+func runGetRegisterAndLeadingZeros(v uint64, precision uint8, t *testing.T) {
 	// (Use str representation to do a manual/different count)
 	bs := fmt.Sprintf("%064b", v)
 
-	first14Str := bs[0:14]
-	first14Int, err := strconv.ParseInt(first14Str, 2, 64)
+	firstPStr := bs[0:precision]
+	firstPInt, err := strconv.ParseUint(firstPStr, 2, 64)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	remnantStr := bs[14:]
-	remnantInt, err := strconv.ParseInt(remnantStr, 2, 64)
+	remnantStr := bs[precision:]
+	remnantInt, err := strconv.ParseUint(remnantStr, 2, 64)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	register, leadingZeros := getRegisterAndLeadingZeros(v)
+	register, leadingZeros := getRegisterAndLeadingZeros(v, precision)
 
-	if uint64(first14Int) != register {
+	if firstPInt != register {
 		t.Fail()
-		t.Logf("register (%d) - wrong register, expected: %d, got: %d", v, first14Int, register)
+		t.Logf("register (%d, p=%d) - wrong register, expected: %d, got: %d", v, precision, firstPInt, register)
 	}
 
-	expectedLeadingZeros := bits.LeadingZeros64(uint64(remnantInt)) - precision
+	expectedLeadingZeros := bits.LeadingZeros64(remnantInt) - int(precision)
 
 	if leadingZeros != uint8(expectedLeadingZeros) {
 		t.Fail()
-		t.Logf("leadingZeros (%d) - wrong count, expected: %d, got: %d", v, expectedLeadingZeros, leadingZeros)
+		t.Logf("leadingZeros (%d, p=%d) - wrong count, expected: %d, got: %d", v, precision, expectedLeadingZeros, leadingZeros)
 	}
 }
 
@@ -119,6 +199,65 @@ func TestSketch_Estimate(t *testing.T) {
 	}
 }
 
+func TestSketch_EstimateWithPrecision(t *testing.T) {
+	rand.Seed(0)
+
+	// Lower precisions have a larger standard error (~1.04/sqrt(2^p)), so only test those within +/-3%.
+	for _, p := range []uint8{12, 16} {
+		s, err := NewSketchWithPrecision(p)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100_000; i++ {
+			s.Insert([]byte(genPseudoRandomStr()))
+		}
+
+		estimate := s.Estimate()
+
+		if !acceptableEstimate(100_000, estimate) {
+			t.Logf("sketch estimate (p=%d) - expected a cardinality +/-3%% of: %d, got: %d", p, 100_000, estimate)
+			t.Fail()
+		}
+	}
+}
+
+// meanRelativeError returns the mean relative error of estimates (using estimator) from trials sketches at
+// precision, each holding cardinality random hashes. Averaging many sketches cancels out the error of each,
+// leaving any bias, which has a standard error of just 1.04/sqrt(registers * trials).
+func meanRelativeError(precision uint8, estimator Estimator, cardinality, trials int) float64 {
+	r := rand.New(rand.NewSource(int64(precision)))
+
+	var sum float64
+
+	for i := 0; i < trials; i++ {
+		s := createSketchWithPrecision(precision)
+
+		for j := 0; j < cardinality; j++ {
+			s.addHash(r.Uint64())
+		}
+
+		sum += float64(s.EstimateWith(estimator))/float64(cardinality) - 1
+	}
+
+	return sum / float64(trials)
+}
+
+func TestSketch_EstimateLowPrecisionBias(t *testing.T) {
+	const trials = 4_000
+
+	// (Precisions with fewer than 128 registers need their own alpha).
+	for p := uint8(MinPrecision); p <= 6; p++ {
+		bias := meanRelativeError(p, EstimatorBiasCorrected, 2_000, trials)
+
+		if limit := 4 * 1.04 / math.Sqrt(float64(trials*(int(1)<<p))); math.Abs(bias) > limit {
+			t.Logf("sketch estimate (p=%d) - expected a mean relative error within: %.4f, got: %.4f", p, limit, bias)
+			t.Fail()
+		}
+	}
+}
+
 func TestSketch_Merge(t *testing.T) {
 	rand.Seed(0)
 
@@ -164,7 +303,7 @@ func TestSketch_MergeBadVersion(t *testing.T) {
 func TestSketch_MergeBadPrecision(t *testing.T) {
 	s1 := createSketch()
 	s2 := createSketch()
-	s2.registers = make([]uint8, 0)
+	s2.registers = make(byteRegisters, 0)
 
 	_, err := s1.Merge(s2)
 
@@ -174,6 +313,18 @@ func TestSketch_MergeBadPrecision(t *testing.T) {
 	}
 }
 
+func TestSketch_MergeDiffPrecision(t *testing.T) {
+	s1 := createSketch()
+	s2 := createSketchWithPrecision(DefaultPrecision + 1)
+
+	_, err := s1.Merge(s2)
+
+	if err != ErrorMalformedPrecision {
+		t.Logf("sketch merge - expected merge with different precision to fail with: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
+
 func TestSketch_ProtoSketch(t *testing.T) {
 	s := NewSketch()
 	ps := s.ProtoSketch()
@@ -212,6 +363,109 @@ func TestNewSketch(t *testing.T) {
 	}
 }
 
+func TestNewSketchWithPrecision(t *testing.T) {
+	for p := uint8(MinPrecision); p <= MaxPrecision; p++ {
+		s, err := NewSketchWithPrecision(p)
+
+		if err != nil {
+			t.Fatalf("new sketch with precision - unexpected error for precision %d: %v", p, err)
+		}
+
+		if s.getPrecision() != p || len(s.getRegisters()) != 1<<p {
+			t.Logf("new sketch with precision - expected precision: %d (%d registers), got: %d (%d registers)", p, 1<<p, s.getPrecision(), len(s.getRegisters()))
+			t.Fail()
+		}
+	}
+}
+
+func TestNewSketchWithPrecision_Invalid(t *testing.T) {
+	for _, p := range []uint8{0, MinPrecision - 1, MaxPrecision + 1} {
+		_, err := NewSketchWithPrecision(p)
+
+		if err == nil {
+			t.Logf("new sketch with precision - expected precision %d to error, but did not", p)
+			t.Fail()
+		}
+	}
+}
+
+func TestNewSketchWithOptions(t *testing.T) {
+	s, err := NewSketchWithOptions(nil)
+
+	if err != nil {
+		t.Fatalf("new sketch with options - unexpected error with default options: %v", err)
+	}
+
+	if s.getPrecision() != DefaultPrecision {
+		t.Logf("new sketch with options - expected default precision: %d, got: %d", DefaultPrecision, s.getPrecision())
+		t.Fail()
+	}
+}
+
+func TestNewSketchWithOptions_ZeroPrecision(t *testing.T) {
+	// (Like every other field, a zero Precision is the default, so options only need the fields they change).
+	options := &SketchOptions{Sparse: true}
+
+	s, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatalf("new sketch with options - unexpected error with a zero precision: %v", err)
+	}
+
+	if s.getPrecision() != DefaultPrecision || sparseOf(s) == nil {
+		t.Logf("new sketch with options - expected a zero precision to give a sparse sketch with precision: %d, got: %d (sparse: %v)", DefaultPrecision, s.getPrecision(), sparseOf(s) != nil)
+		t.Fail()
+	}
+
+	concurrent, err := NewConcurrentSketch(options)
+
+	if err != nil || concurrent.getPrecision() != DefaultPrecision {
+		t.Logf("new concurrent sketch - expected a zero precision to give precision: %d (err: %v)", DefaultPrecision, err)
+		t.Fail()
+	}
+
+	sharded, err := NewShardedSketch(options)
+
+	if err != nil || sharded.getPrecision() != DefaultPrecision {
+		t.Logf("new sharded sketch - expected a zero precision to give precision: %d (err: %v)", DefaultPrecision, err)
+		t.Fail()
+	}
+
+	window, err := NewWindowSketch(time.Hour, options)
+
+	if err != nil || window.config.precision != DefaultPrecision {
+		t.Logf("new window sketch - expected a zero precision to give precision: %d (err: %v)", DefaultPrecision, err)
+		t.Fail()
+	}
+
+	if _, err := NewTimeSeries(DefaultTimeSeriesLevels(), options); err != nil {
+		t.Logf("new time series - unexpected error with a zero precision: %v", err)
+		t.Fail()
+	}
+
+	if _, err := NewSketchMap(&SketchMapOptions{SketchOptions: options}); err != nil {
+		t.Logf("new sketch map - unexpected error with a zero precision: %v", err)
+		t.Fail()
+	}
+}
+
+func TestNewSketchWithOptions_Invalid(t *testing.T) {
+	invalid := []*SketchOptions{
+		{Precision: MinPrecision - 1},
+		{Precision: MaxPrecision + 1},
+		{Precision: DefaultPrecision, BiasKey: "not-registered"},
+	}
+
+	for _, options := range invalid {
+		_, err := NewSketchWithOptions(options)
+
+		if err == nil {
+			t.Logf("new sketch with options - expected options %+v to error, but did not", options)
+			t.Fail()
+		}
+	}
+}
+
 func TestNewCustomSketch(t *testing.T) {
 	testBiases := map[int]float64{
 		0: 0.0,
@@ -329,6 +583,105 @@ func TestFromProtoSketch_NoRegisters(t *testing.T) {
 	}
 }
 
+func TestFromProtoSketch_InvalidRegisterCount(t *testing.T) {
+	for _, n := range []int{1, 100, 1 << (MaxPrecision + 1)} {
+		_, err := FromProtoSketch(&hllProto.Sketch{Version: currentVersion, Registers: make([]uint32, n)})
+
+		if err != ErrorMalformedPrecision {
+			t.Logf("from proto sketch - expected %d registers to fail with: %v, got: %v", n, ErrorMalformedPrecision, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestFromProtoSketch_InvalidRegister(t *testing.T) {
+	for _, precision := range []uint8{MinPrecision, DefaultPrecision, MaxPrecision} {
+		largest := uint32(maxRankAt(precision))
+
+		for _, rank := range []uint32{largest, largest + 1, 256, math.MaxUint32} {
+			registers := make([]uint32, 1<<precision)
+			registers[len(registers)-1] = rank
+
+			_, err := FromProtoSketch(&hllProto.Sketch{Version: currentVersion, Registers: registers})
+
+			if rank <= largest && err != nil {
+				t.Logf("from proto sketch - expected register of %d to be valid at precision %d, got: %v", rank, precision, err)
+				t.Fail()
+			}
+
+			if rank > largest && !errors.Is(err, ErrorMalformedRegister) {
+				t.Logf("from proto sketch - expected register of %d to fail at precision %d with: %v, got: %v", rank, precision, ErrorMalformedRegister, err)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestFromProtoSketch_UnsupportedVersion(t *testing.T) {
+	for _, version := range []string{"", "0", "2", "vTEST;seed=1"} {
+		_, err := FromProtoSketch(&hllProto.Sketch{Version: version, Registers: make([]uint32, 1<<DefaultPrecision)})
+
+		if !errors.Is(err, ErrorUnsupportedVersion) {
+			t.Logf("from proto sketch - expected version %q to fail with: %v, got: %v", version, ErrorUnsupportedVersion, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestProtoDeserialize_MalformedErrors(t *testing.T) {
+	registers := make([]uint32, 1<<DefaultPrecision)
+
+	malformed := [][]byte{
+		nil,
+		[]byte("garbage"),
+		mustMarshal(t, &hllProto.Sketch{Version: currentVersion + ";seedfp", Registers: registers}),
+		mustMarshal(t, &hllProto.Sketch{Version: currentVersion + ";seedfp=0", Registers: registers}),
+		mustMarshal(t, &hllProto.Sketch{Version: currentVersion + ";seedfp=1;hash=murmur3_128", Registers: registers}),
+		mustMarshal(t, &hllProto.Sketch{Version: currentVersion + ";unknown=1", Registers: registers}),
+	}
+
+	for _, bs := range malformed {
+		if _, err := ProtoDeserialize(bs); !errors.Is(err, ErrorMalformedSketch) {
+			t.Logf("proto deserialize - expected %q to fail with: %v, got: %v", bs, ErrorMalformedSketch, err)
+			t.Fail()
+		}
+	}
+
+	if _, err := FromProtoSketch(nil); !errors.Is(err, ErrorMalformedSketch) {
+		t.Logf("from proto sketch - expected nil to fail with: %v, got: %v", ErrorMalformedSketch, err)
+		t.Fail()
+	}
+}
+
+func mustMarshal(t *testing.T, sketch *hllProto.Sketch) []byte {
+	bs, err := proto.Marshal(sketch)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bs
+}
+
+func TestFromProtoSketch_Precision(t *testing.T) {
+	s0, err := NewSketchWithPrecision(10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s1, err := FromProtoSketch(s0.ProtoSketch())
+
+	if err != nil {
+		t.Fatalf("from proto sketch - unexpected error going back to Sketch from proto: %v", err)
+	}
+
+	if s1.getPrecision() != 10 {
+		t.Logf("from proto sketch - expected precision: %d, got: %d", 10, s1.getPrecision())
+		t.Fail()
+	}
+}
+
 func TestFromProtoSketch(t *testing.T) {
 	s0 := NewSketch()
 	ps0 := s0.ProtoSketch()
@@ -345,3 +698,225 @@ func TestFromProtoSketch(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSketch_Clone(t *testing.T) {
+	rand.Seed(0)
+
+	sketches := []Sketch{createSparseSketch(t)}
+
+	for _, encoding := range testEncodings {
+		options := DefaultSketchOptions()
+		options.Encoding = encoding
+
+		s, err := NewSketchWithOptions(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sketches = append(sketches, s)
+	}
+
+	for _, s := range sketches {
+		for i := 0; i < 100; i++ {
+			s.Insert([]byte(genPseudoRandomStr()))
+		}
+
+		estimate := s.Estimate()
+		c := s.Clone()
+
+		if c.Estimate() != estimate {
+			t.Fatalf("sketch clone - expected clone estimate: %d, got: %d", estimate, c.Estimate())
+		}
+
+		for i := 0; i < 100; i++ {
+			c.Insert([]byte(genPseudoRandomStr()))
+		}
+
+		if s.Estimate() != estimate {
+			t.Logf("sketch clone - expected inserting into clone to leave estimate at: %d, got: %d", estimate, s.Estimate())
+			t.Fail()
+		}
+
+		if !acceptableEstimate(200, c.Estimate()) {
+			t.Logf("sketch clone - expected a cardinality +/-3%% of: %d, got: %d", 200, c.Estimate())
+			t.Fail()
+		}
+	}
+}
+
+func BenchmarkSketch_Estimate(b *testing.B) {
+	s := createSketch()
+
+	for i := 0; i < 100_000; i++ {
+		s.InsertUint64(uint64(i))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// (Estimates between inserts, as when driving rate-limits).
+		s.InsertUint64(uint64(i))
+		s.Estimate()
+	}
+}
+
+// BenchmarkSketch_EstimateUncached measures estimates that recount the registers, as after a Merge (or from a
+// ConcurrentSketch or ShardedSketch snapshot), at increasing fill levels.
+func BenchmarkSketch_EstimateUncached(b *testing.B) {
+	for _, n := range []int{100, 10_000, 1_000_000} {
+		s := createSketch()
+
+		for i := 0; i < n; i++ {
+			s.InsertUint64(uint64(i))
+		}
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.SetBytes(int64(s.registerCount()))
+
+			for i := 0; i < b.N; i++ {
+				s.counts = nil
+				s.Estimate()
+			}
+		})
+	}
+}
+
+// checkDecodedSketch checks that a successfully decoded s re-encodes to bytes that decode to the same sketch
+// (and encode the same again), and that merging s with a fresh sketch (in either direction) never corrupts
+// either.
+func checkDecodedSketch(t *testing.T, s Sketch) {
+	bs, err := s.ProtoSerialize()
+
+	if err != nil {
+		t.Fatalf("decoded sketch - unexpected error re-encoding: %v", err)
+	}
+
+	decoded, err := ProtoDeserializeWithSeed(bs, s.getSeed())
+
+	if err != nil {
+		t.Fatalf("decoded sketch - unexpected error decoding re-encoded sketch: %v", err)
+	}
+
+	again, err := decoded.ProtoSerialize()
+
+	if err != nil || !bytes.Equal(bs, again) {
+		t.Fatalf("decoded sketch - expected re-encoding to be stable, got: %q then: %q (err: %v)", bs, again, err)
+	}
+
+	registers := append([]uint8(nil), s.getRegisters()...)
+
+	fresh := s.emptyCopy()
+
+	for i := 0; i < 100; i++ {
+		fresh.InsertUint64(uint64(i))
+	}
+
+	freshRegisters := append([]uint8(nil), fresh.getRegisters()...)
+
+	merged, err := s.Clone().Merge(fresh)
+
+	if err != nil {
+		t.Fatalf("decoded sketch - unexpected error merging fresh sketch: %v", err)
+	}
+
+	into, err := fresh.Clone().Merge(s)
+
+	if err != nil {
+		t.Fatalf("decoded sketch - unexpected error merging into fresh sketch: %v", err)
+	}
+
+	for i, r := range s.getRegisters() {
+		expected := registers[i]
+
+		if freshRegisters[i] > expected {
+			expected = freshRegisters[i]
+		}
+
+		if r != registers[i] || fresh.getRegisters()[i] != freshRegisters[i] {
+			t.Fatalf("decoded sketch - register %d changed by merge: %d (fresh: %d), now: %d (fresh: %d)", i, registers[i], freshRegisters[i], r, fresh.getRegisters()[i])
+		}
+
+		if merged.getRegisters()[i] != expected || into.getRegisters()[i] != expected {
+			t.Fatalf("decoded sketch - merged register %d expected: %d, got: %d (and into fresh: %d)", i, expected, merged.getRegisters()[i], into.getRegisters()[i])
+		}
+
+		if decoded.getRegisters()[i] != r {
+			t.Fatalf("decoded sketch - re-decoded register %d expected: %d, got: %d", i, r, decoded.getRegisters()[i])
+		}
+	}
+
+	merged.Estimate()
+	merged.EstimateWithBounds(0.95)
+}
+
+// fuzzSeeds are the seeds of the sketches in testdata/fuzz (0 for the unseeded ones).
+var fuzzSeeds = []uint64{0, 1, 0x5eed}
+
+// decodeFuzzed calls decode with each of fuzzSeeds until it doesn't fail with ErrorMismatchedSeed, returning
+// the seed it stopped at.
+func decodeFuzzed(decode func(seed uint64) error) (uint64, error) {
+	var err error
+
+	for _, seed := range fuzzSeeds {
+		if err = decode(seed); !errors.Is(err, ErrorMismatchedSeed) {
+			return seed, err
+		}
+	}
+
+	return 0, err
+}
+
+// FuzzProtoDeserialize checks decoding arbitrary bytes never panics, and that anything it decodes is sound.
+// (Seeded from real sketches, in testdata/fuzz. Some are full size, so use a small -fuzzminimizetime (e.g. 10x)
+// to keep the fuzzer from stalling while it minimises them).
+func FuzzProtoDeserialize(f *testing.F) {
+	f.Add([]byte("garbage"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var s Sketch
+
+		_, err := decodeFuzzed(func(seed uint64) (err error) {
+			s, err = ProtoDeserializeWithSeed(data, seed)
+			return err
+		})
+
+		if err != nil {
+			return
+		}
+
+		checkDecodedSketch(t, s)
+	})
+}
+
+// FuzzFromProtoSketch checks FromProtoSketch with arbitrary versions and registers (one per byte of
+// registers, with last, if non-zero, replacing the last to reach values past a byte).
+func FuzzFromProtoSketch(f *testing.F) {
+	f.Add(currentVersion, make([]byte, 1<<MinPrecision), uint32(0))
+	f.Add(fmt.Sprintf("%s;seedfp=%x", currentVersion, seedFingerprint(1)), []byte("0123456789abcdef"), uint32(1<<8))
+
+	f.Fuzz(func(t *testing.T, version string, registers []byte, last uint32) {
+		registerspb := make([]uint32, len(registers))
+
+		for i, r := range registers {
+			registerspb[i] = uint32(r)
+		}
+
+		if last != 0 && len(registerspb) > 0 {
+			registerspb[len(registerspb)-1] = last
+		}
+
+		var s Sketch
+
+		_, err := decodeFuzzed(func(seed uint64) (err error) {
+			s, err = FromProtoSketchWithSeed(&hllProto.Sketch{Version: version, Registers: registerspb}, seed)
+			return err
+		})
+
+		if err != nil {
+			return
+		}
+
+		checkDecodedSketch(t, s)
+	})
+}
@@ -0,0 +1,426 @@
+package hll
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// RegisterEncoding selects how a Sketch's (dense) registers are stored in memory. It has no effect on
+// estimates, merges or serialization - Sketches with different encodings can be freely merged together.
+type RegisterEncoding int
+
+const (
+	// Encoding8Bit stores each register in a single byte. This is the fastest encoding, and the default.
+	Encoding8Bit RegisterEncoding = iota
+
+	// Encoding6Bit packs each register into 6 bits (as in Redis), using 3/4 of the memory of Encoding8Bit.
+	Encoding6Bit
+
+	// Encoding4Bit stores each register in 4 bits relative to the smallest register value, with an overflow
+	// table for the (rare) registers that don't fit (as in DataSketches' HLL_4). This uses roughly 1/2 of the
+	// memory of Encoding8Bit, at the cost of slower inserts.
+	Encoding4Bit
+)
+
+func (e RegisterEncoding) valid() bool {
+	return e >= Encoding8Bit && e <= Encoding4Bit
+}
+
+func (e RegisterEncoding) String() string {
+	switch e {
+	case Encoding8Bit:
+		return "8bit"
+	case Encoding6Bit:
+		return "6bit"
+	case Encoding4Bit:
+		return "4bit"
+	}
+
+	return fmt.Sprintf("RegisterEncoding(%d)", int(e))
+}
+
+// registerArray is the storage for a (dense) Sketch's registers.
+type registerArray interface {
+	// get returns the value of register i.
+	get(i uint64) uint8
+
+	// set sets register i to v, which must be greater than the current value of register i. (Registers
+	// only ever increase, which packed encodings rely on).
+	set(i uint64, v uint8)
+
+	// count returns the number of registers.
+	count() int
+
+	// size returns the (approximate) number of bytes used to hold the registers.
+	size() int
+
+	// clone returns a deep copy of the registers.
+	clone() registerArray
+}
+
+func newRegisterArray(encoding RegisterEncoding, count int) registerArray {
+	switch encoding {
+	case Encoding6Bit:
+		return newPackedRegisters(count)
+	case Encoding4Bit:
+		return newNibbleRegisters(count)
+	}
+
+	return make(byteRegisters, count)
+}
+
+// registerArraySize returns the number of bytes used by a registerArray with count registers.
+func registerArraySize(encoding RegisterEncoding, count int) int {
+	switch encoding {
+	case Encoding6Bit:
+		return count*6/8 + 1
+	case Encoding4Bit:
+		return count / 2
+	}
+
+	return count
+}
+
+// toBytes returns the registers of r as a []uint8. For byteRegisters this is the underlying slice, for anything
+// else this is a (new) materialised copy.
+func toBytes(r registerArray) []uint8 {
+	if b, ok := r.(byteRegisters); ok {
+		return b
+	}
+
+	registers := make([]uint8, r.count())
+
+	for i := range registers {
+		registers[i] = r.get(uint64(i))
+	}
+
+	return registers
+}
+
+// mergeBytes sets each register in r to the max of itself and the corresponding register in other.
+func mergeBytes(r registerArray, other []uint8) {
+	if b, ok := r.(byteRegisters); ok {
+		maxBytes(b, other)
+		return
+	}
+
+	for i, otherZeros := range other {
+		if otherZeros > r.get(uint64(i)) {
+			r.set(uint64(i), otherZeros)
+		}
+	}
+}
+
+const (
+	// (Masks of the high bit, and the low 7 bits, of each byte in a word).
+	highBits = 0x8080808080808080
+	lowBits  = 0x7F7F7F7F7F7F7F7F
+)
+
+// maxBytes sets each byte of b to the max of itself and the corresponding byte of other (which must be at least
+// as long). Bytes are compared a word (8 at a time) using SWAR ("SIMD within a register"), which gives exactly
+// the same result as maxBytesScalar.
+func maxBytes(b, other []uint8) {
+	for len(b) >= 8 && len(other) >= 8 {
+		x := binary.LittleEndian.Uint64(b)
+		y := binary.LittleEndian.Uint64(other)
+
+		// (Spread each high bit across its byte, to select from x or y).
+		mask := (geBytes(x, y) >> 7) * 0xFF
+
+		binary.LittleEndian.PutUint64(b, x&mask|y&^mask)
+
+		b, other = b[8:], other[8:]
+	}
+
+	maxBytesScalar(b, other)
+}
+
+// geBytes returns the high bit of each byte set where that byte of x is >= the same byte of y.
+func geBytes(x, y uint64) uint64 {
+	// Each byte of x with its high bit set can't borrow from the next, so this sets the high bit of each byte
+	// where the low 7 bits of x are >= those of y...
+	low := ((x | highBits) - (y & lowBits)) & highBits
+
+	// ...then x >= y where x has the high bit and y doesn't, or they match and the low 7 bits decide.
+	return (x&^y | ^(x^y)&low) & highBits
+}
+
+// mergeBytesCounted is mergeBytes, but also keeps counts (the histogram of r) up to date as registers are raised.
+// Byte registers skip words where nothing is raised (most of them, once sketches are similar), a word at a time.
+func mergeBytesCounted(r registerArray, other []uint8, counts []int) {
+	b, ok := r.(byteRegisters)
+
+	if !ok {
+		for i, otherZeros := range other {
+			if previous := r.get(uint64(i)); otherZeros > previous {
+				r.set(uint64(i), otherZeros)
+
+				counts[previous] -= 1
+				counts[otherZeros] += 1
+			}
+		}
+
+		return
+	}
+
+	for len(b) >= 8 && len(other) >= 8 {
+		if geBytes(binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint64(other)) != highBits {
+			maxBytesScalarCounted(b[:8], other[:8], counts)
+		}
+
+		b, other = b[8:], other[8:]
+	}
+
+	maxBytesScalarCounted(b, other, counts)
+}
+
+// maxBytesScalarCounted is maxBytesScalar, also moving each raised byte between counts.
+func maxBytesScalarCounted(b, other []uint8, counts []int) {
+	for i, thisZeros := range b {
+		otherZeros := other[i]
+
+		if otherZeros > thisZeros {
+			b[i] = otherZeros
+
+			counts[thisZeros] -= 1
+			counts[otherZeros] += 1
+		}
+	}
+}
+
+// maxBytesScalar is maxBytes, a byte at a time.
+func maxBytesScalar(b, other []uint8) {
+	for i, thisZeros := range b {
+		otherZeros := other[i]
+
+		if otherZeros > thisZeros {
+			b[i] = otherZeros
+		}
+	}
+}
+
+// countBytes adds the number of registers in b holding each value to counts. Registers are read a word (8 at
+// a time), so runs of untouched registers (most of them, at low fill) are counted without looking at each.
+func countBytes(b byteRegisters, counts []int) {
+	i := 0
+
+	for ; i+8 <= len(b); i += 8 {
+		word := binary.LittleEndian.Uint64(b[i:])
+
+		if word == 0 {
+			counts[0] += 8
+			continue
+		}
+
+		counts[uint8(word)] += 1
+		counts[uint8(word>>8)] += 1
+		counts[uint8(word>>16)] += 1
+		counts[uint8(word>>24)] += 1
+		counts[uint8(word>>32)] += 1
+		counts[uint8(word>>40)] += 1
+		counts[uint8(word>>48)] += 1
+		counts[uint8(word>>56)] += 1
+	}
+
+	for ; i < len(b); i++ {
+		counts[b[i]] += 1
+	}
+}
+
+// byteRegisters stores each register in a single byte (Encoding8Bit).
+type byteRegisters []uint8
+
+func (b byteRegisters) get(i uint64) uint8 {
+	return b[i]
+}
+
+func (b byteRegisters) set(i uint64, v uint8) {
+	b[i] = v
+}
+
+func (b byteRegisters) count() int {
+	return len(b)
+}
+
+func (b byteRegisters) size() int {
+	return len(b)
+}
+
+func (b byteRegisters) clone() registerArray {
+	return append(byteRegisters(nil), b...)
+}
+
+const packedBits = 6
+
+// packedRegisters stores each register in 6 bits (Encoding6Bit), little-endian, so register i starts at bit 6*i.
+// Registers can straddle two bytes, so bytes has an extra trailing byte to avoid bounds checks on the last.
+// (Ranks never exceed maxRank, so always fit).
+type packedRegisters struct {
+	bytes []byte
+	n     int
+}
+
+func newPackedRegisters(count int) *packedRegisters {
+	return &packedRegisters{
+		bytes: make([]byte, registerArraySize(Encoding6Bit, count)),
+		n:     count,
+	}
+}
+
+func (p *packedRegisters) get(i uint64) uint8 {
+	bit := i * packedBits
+	b, shift := bit/8, bit%8
+
+	v := uint16(p.bytes[b]) | uint16(p.bytes[b+1])<<8
+
+	return uint8(v>>shift) & (1<<packedBits - 1)
+}
+
+func (p *packedRegisters) set(i uint64, v uint8) {
+	bit := i * packedBits
+	b, shift := bit/8, bit%8
+
+	word := uint16(p.bytes[b]) | uint16(p.bytes[b+1])<<8
+	word &^= (1<<packedBits - 1) << shift
+	word |= uint16(v) << shift
+
+	p.bytes[b] = uint8(word)
+	p.bytes[b+1] = uint8(word >> 8)
+}
+
+func (p *packedRegisters) count() int {
+	return p.n
+}
+
+func (p *packedRegisters) size() int {
+	return len(p.bytes)
+}
+
+func (p *packedRegisters) clone() registerArray {
+	return &packedRegisters{
+		bytes: append([]byte(nil), p.bytes...),
+		n:     p.n,
+	}
+}
+
+// nibbleOverflow marks a register whose value doesn't fit in a nibble, and can be found in overflow instead.
+const nibbleOverflow = 0xF
+
+// nibbleRegisters stores each register in 4 bits (Encoding4Bit) as its difference from offset, which is the
+// smallest value of any register. Since registers tend to cluster closely around the mean, very few registers
+// are >= offset+15 and these are kept in overflow. Once no registers are left at offset, it is increased (and
+// every nibble is shifted down by 1).
+type nibbleRegisters struct {
+	nibbles []byte
+
+	offset   uint8
+	atOffset int
+
+	overflow map[uint64]uint8
+}
+
+func newNibbleRegisters(count int) *nibbleRegisters {
+	return &nibbleRegisters{
+		nibbles:  make([]byte, registerArraySize(Encoding4Bit, count)),
+		atOffset: count,
+		overflow: map[uint64]uint8{},
+	}
+}
+
+func (nr *nibbleRegisters) nibble(i uint64) uint8 {
+	return (nr.nibbles[i/2] >> ((i % 2) * 4)) & 0xF
+}
+
+func (nr *nibbleRegisters) setNibble(i uint64, v uint8) {
+	shift := (i % 2) * 4
+	nr.nibbles[i/2] = nr.nibbles[i/2]&^(0xF<<shift) | v<<shift
+}
+
+func (nr *nibbleRegisters) get(i uint64) uint8 {
+	n := nr.nibble(i)
+
+	if n == nibbleOverflow {
+		return nr.overflow[i]
+	}
+
+	return nr.offset + n
+}
+
+func (nr *nibbleRegisters) set(i uint64, v uint8) {
+	old := nr.get(i)
+
+	if v-nr.offset >= nibbleOverflow {
+		nr.setNibble(i, nibbleOverflow)
+		nr.overflow[i] = v
+	} else {
+		nr.setNibble(i, v-nr.offset)
+	}
+
+	if old != nr.offset {
+		return
+	}
+
+	nr.atOffset -= 1
+
+	for nr.atOffset <= 0 {
+		nr.increaseOffset()
+	}
+}
+
+// increaseOffset increases offset by 1, shifting every nibble down to match, and pulling in any overflowed
+// registers that now fit.
+func (nr *nibbleRegisters) increaseOffset() {
+	nr.offset += 1
+	nr.atOffset = 0
+
+	n := uint64(nr.count())
+
+	for i := uint64(0); i < n; i++ {
+		v := nr.nibble(i)
+
+		if v == nibbleOverflow {
+			continue
+		}
+
+		// (No nibble can be 0 here, since no registers were left at the old offset)
+		if v-1 == 0 {
+			nr.atOffset += 1
+		}
+
+		nr.setNibble(i, v-1)
+	}
+
+	for i, v := range nr.overflow {
+		if v-nr.offset >= nibbleOverflow {
+			continue
+		}
+
+		nr.setNibble(i, v-nr.offset)
+		delete(nr.overflow, i)
+	}
+}
+
+func (nr *nibbleRegisters) count() int {
+	return len(nr.nibbles) * 2
+}
+
+func (nr *nibbleRegisters) size() int {
+	// (Roughly 16 bytes per overflowed register in a map[uint64]uint8).
+	return len(nr.nibbles) + 16*len(nr.overflow)
+}
+
+func (nr *nibbleRegisters) clone() registerArray {
+	overflow := make(map[uint64]uint8, len(nr.overflow))
+
+	for i, v := range nr.overflow {
+		overflow[i] = v
+	}
+
+	return &nibbleRegisters{
+		nibbles:  append([]byte(nil), nr.nibbles...),
+		offset:   nr.offset,
+		atOffset: nr.atOffset,
+		overflow: overflow,
+	}
+}
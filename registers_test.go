@@ -0,0 +1,291 @@
+package hll
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var testEncodings = []RegisterEncoding{Encoding8Bit, Encoding6Bit, Encoding4Bit}
+
+// runRegisterArray applies the same (increasing) updates to r and a plain []uint8, then compares them.
+func runRegisterArray(t *testing.T, r registerArray, updates int) {
+	expected := make([]uint8, r.count())
+
+	for u := 0; u < updates; u++ {
+		i := rand.Intn(len(expected))
+
+		// Mostly small values (as from a sketch), with the occasional large one to force overflows.
+		v := uint8(rand.Intn(8)) + 1
+		if rand.Intn(100) == 0 {
+			v = uint8(rand.Intn(61)) + 1
+		}
+
+		if v <= expected[i] {
+			continue
+		}
+
+		expected[i] = v
+		r.set(uint64(i), v)
+	}
+
+	for i, v := range expected {
+		if r.get(uint64(i)) != v {
+			t.Fatalf("register array (%T) - register %d expected: %d, got: %d", r, i, v, r.get(uint64(i)))
+		}
+	}
+}
+
+func TestRegisterArray(t *testing.T) {
+	rand.Seed(0)
+
+	for _, encoding := range testEncodings {
+		for _, count := range []int{1 << MinPrecision, 1 << 10} {
+			r := newRegisterArray(encoding, count)
+
+			if r.count() != count {
+				t.Fatalf("register array (%v) - expected count: %d, got: %d", encoding, count, r.count())
+			}
+
+			runRegisterArray(t, r, count*20)
+		}
+	}
+}
+
+func TestCountBytes(t *testing.T) {
+	rand.Seed(0)
+
+	// (Lengths that aren't a multiple of a word, with runs of untouched registers).
+	for _, n := range []int{0, 7, 16, 1_001} {
+		b := make(byteRegisters, n)
+
+		for i := range b {
+			if rand.Intn(3) == 0 {
+				b[i] = uint8(rand.Intn(61)) + 1
+			}
+		}
+
+		expected := make([]int, hashLength)
+		counts := make([]int, hashLength)
+
+		for _, v := range b {
+			expected[v] += 1
+		}
+
+		countBytes(b, counts)
+
+		for v := range expected {
+			if counts[v] != expected[v] {
+				t.Fatalf("count bytes (%d registers) - expected count of %d: %d, got: %d", n, v, expected[v], counts[v])
+			}
+		}
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	// (Every pair of byte values, including those past any valid register, in both words and the tail).
+	for x := 0; x < 256; x++ {
+		b := make([]uint8, 259)
+		other := make([]uint8, 259)
+		expected := make([]uint8, 259)
+
+		for y := range other {
+			b[y] = uint8(x)
+			other[y] = uint8(y)
+			expected[y] = uint8(x)
+		}
+
+		maxBytes(b, other)
+		maxBytesScalar(expected, other)
+
+		for i := range expected {
+			if b[i] != expected[i] {
+				t.Fatalf("max bytes - expected max(%d, %d): %d, got: %d", x, other[i], expected[i], b[i])
+			}
+		}
+	}
+}
+
+// FuzzMaxBytes checks that maxBytes (SWAR) matches maxBytesScalar, with data split into the two halves.
+func FuzzMaxBytes(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 8, 7, 6, 5, 4, 3, 2, 1})
+	f.Add([]byte{0x7F, 0x80, 0xFF, 0, 0x80, 0x7F, 0, 0xFF, 1, 0xFE, 0x81, 0x7E, 0xFF, 0x80, 0x7F, 0, 61, 62})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		n := len(data) / 2
+
+		b := append([]byte(nil), data[:n]...)
+		expected := append([]byte(nil), data[:n]...)
+		other := data[n : 2*n]
+
+		counted := append([]byte(nil), data[:n]...)
+		counts := make([]int, 256)
+		countBytes(counted, counts)
+
+		maxBytes(b, other)
+		maxBytesScalar(expected, other)
+		mergeBytesCounted(byteRegisters(counted), other, counts)
+
+		for i := range expected {
+			if b[i] != expected[i] || counted[i] != expected[i] {
+				t.Fatalf("max bytes - register %d expected: %d, got: %d (counted: %d)", i, expected[i], b[i], counted[i])
+			}
+		}
+
+		fresh := make([]int, 256)
+		countBytes(expected, fresh)
+
+		for v, count := range fresh {
+			if counts[v] != count {
+				t.Fatalf("max bytes counted - expected count of %d: %d, got: %d", v, count, counts[v])
+			}
+		}
+	})
+}
+
+func BenchmarkMaxBytes(b *testing.B) {
+	rand.Seed(0)
+
+	registers := make([]uint8, 1<<DefaultPrecision)
+	other := make([]uint8, 1<<DefaultPrecision)
+
+	for i := range registers {
+		registers[i] = uint8(rand.Intn(8))
+		other[i] = uint8(rand.Intn(8))
+	}
+
+	benchmarks := []struct {
+		name string
+		fn   func(b, other []uint8)
+	}{
+		{"swar", maxBytes},
+		{"scalar", maxBytesScalar},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(registers)))
+
+			for i := 0; i < b.N; i++ {
+				bm.fn(registers, other)
+			}
+		})
+	}
+}
+
+func TestNibbleRegisters_Offset(t *testing.T) {
+	nr := newNibbleRegisters(16)
+
+	for i := uint64(0); i < 16; i++ {
+		nr.set(i, 2)
+	}
+
+	// Every register is at least 2, so offset should have moved up.
+	if nr.offset != 2 || nr.atOffset != 16 {
+		t.Fatalf("nibble registers - expected offset: %d (with %d registers), got: %d (with %d registers)", 2, 16, nr.offset, nr.atOffset)
+	}
+
+	nr.set(0, 40)
+
+	if len(nr.overflow) != 1 || nr.get(0) != 40 {
+		t.Fatalf("nibble registers - expected register 0 to overflow with: %d, got: %d (%d overflowed)", 40, nr.get(0), len(nr.overflow))
+	}
+
+	for i := uint64(1); i < 16; i++ {
+		nr.set(i, 30)
+	}
+
+	// Offset is now 30, so register 0 (40) fits in a nibble again.
+	if nr.offset != 30 || len(nr.overflow) != 0 || nr.get(0) != 40 {
+		t.Fatalf("nibble registers - expected overflow to be pulled back in at offset %d, got offset: %d (%d overflowed)", 30, nr.offset, len(nr.overflow))
+	}
+}
+
+func TestSketch_Encodings(t *testing.T) {
+	rand.Seed(0)
+
+	expected := createSketch()
+	sketches := make([]Sketch, len(testEncodings))
+
+	for i, encoding := range testEncodings {
+		options := DefaultSketchOptions()
+		options.Encoding = encoding
+
+		s, err := NewSketchWithOptions(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sketches[i] = s
+	}
+
+	for i := 0; i < 50_000; i++ {
+		element := []byte(genPseudoRandomStr())
+
+		expected.Insert(element)
+
+		for _, s := range sketches {
+			s.Insert(element)
+		}
+	}
+
+	for i, s := range sketches {
+		if s.Estimate() != expected.Estimate() {
+			t.Logf("sketch encodings (%v) - expected estimate: %d, got: %d", testEncodings[i], expected.Estimate(), s.Estimate())
+			t.Fail()
+		}
+
+		registers := s.getRegisters()
+
+		for j, r := range expected.getRegisters() {
+			if registers[j] != r {
+				t.Fatalf("sketch encodings (%v) - register %d expected: %d, got: %d", testEncodings[i], j, r, registers[j])
+			}
+		}
+	}
+}
+
+func TestSketch_MergeEncodings(t *testing.T) {
+	rand.Seed(0)
+
+	for _, encoding := range testEncodings {
+		options := DefaultSketchOptions()
+		options.Encoding = encoding
+
+		s0, err := NewSketchWithOptions(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s1 := createSketch()
+
+		for i := 0; i < 50_000; i++ {
+			s0.Insert([]byte(genPseudoRandomStr()))
+			s1.Insert([]byte(genPseudoRandomStr()))
+		}
+
+		_, err = s0.Merge(s1)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !acceptableEstimate(100_000, s0.Estimate()) {
+			t.Logf("sketch merge (%v) - expected a cardinality +/-3%% of: %d, got: %d", encoding, 100_000, s0.Estimate())
+			t.Fail()
+		}
+	}
+}
+
+func TestNewSketchWithOptions_InvalidEncoding(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Encoding = Encoding4Bit + 1
+
+	_, err := NewSketchWithOptions(options)
+
+	if err == nil {
+		t.Fatalf("new sketch with options - expected unknown encoding to error, but did not")
+	}
+}
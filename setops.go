@@ -0,0 +1,128 @@
+package hll
+
+import "fmt"
+
+// UnionEstimate returns an estimate of the number of unique elements inserted into any of sketches, without
+// modifying them (unlike Merge). It will error if sketches could not be merged together.
+func UnionEstimate(sketches ...Sketch) (uint64, error) {
+	if len(sketches) <= 0 {
+		return 0, fmt.Errorf("union estimate requires at least one sketch")
+	}
+
+	for _, sk := range sketches[1:] {
+		if err := checkMergeable(sketches[0], sk); err != nil {
+			return 0, err
+		}
+	}
+
+	// Take the max of each register into a scratch (Encoding8Bit) sketch, configured as the first.
+	union := sketches[0].emptyCopy()
+
+	if _, ok := union.registers.(byteRegisters); !ok {
+		union.sparse = nil
+		union.encoding = Encoding8Bit
+		union.registers = make(byteRegisters, union.registerCount())
+	}
+
+	for _, sk := range sketches {
+		if sp := sparseOf(sk); sp != nil {
+			sp.toDense(union.registers)
+			continue
+		}
+
+		registers := sk.getRegisters()
+
+		if len(registers) != union.registerCount() {
+			return 0, ErrorMalformedPrecision
+		}
+
+		mergeBytes(union.registers, registers)
+	}
+
+	return union.Estimate(), nil
+}
+
+// IntersectionEstimate returns an estimate of the number of elements inserted into both a and b, using
+// inclusion-exclusion (|A| + |B| - |union(A, B)|). Neither a nor b are modified. It will error if a and b
+// could not be merged.
+//
+// NOTE: The error of an intersection is relative to the size of the union, not the intersection, so small
+// intersections of large sets are estimated poorly.
+func IntersectionEstimate(a, b Sketch) (uint64, error) {
+	union, err := unionOf(a, b)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return intersectionEstimate(a, b, union), nil
+}
+
+// Jaccard returns an estimate of the Jaccard index (|intersection(A, B)| / |union(A, B)|) of the elements
+// inserted into a and b, or 0 if both are empty. Neither a nor b are modified. It will error if a and b could
+// not be merged.
+func Jaccard(a, b Sketch) (float64, error) {
+	union, err := unionOf(a, b)
+
+	if err != nil {
+		return 0, err
+	}
+
+	unionEstimate := union.Estimate()
+
+	if unionEstimate == 0 {
+		return 0, nil
+	}
+
+	return float64(intersectionEstimate(a, b, union)) / float64(unionEstimate), nil
+}
+
+// unionOf returns a new sketch (configured as a) holding the union of a and b.
+func unionOf(a, b Sketch) (*sketch, error) {
+	if err := checkMergeable(a, b); err != nil {
+		return nil, err
+	}
+
+	union := a.emptyCopy()
+
+	if _, err := union.Merge(a); err != nil {
+		return nil, err
+	}
+
+	if _, err := union.Merge(b); err != nil {
+		return nil, err
+	}
+
+	return union, nil
+}
+
+// intersectionEstimate returns |A| + |B| - |union(A, B)|, clamped to [0, min(|A|, |B|)]. Every estimate uses the
+// estimator of union, so they're comparable.
+func intersectionEstimate(a, b Sketch, union *sketch) uint64 {
+	aEstimate := a.EstimateWith(union.estimator)
+	bEstimate := b.EstimateWith(union.estimator)
+	unionEstimate := union.Estimate()
+
+	// (Rearranged so saturated estimates, e.g. math.MaxUint64 from EstimatorMLE, can't overflow).
+	var intersection uint64
+
+	switch {
+	case aEstimate > unionEstimate:
+		// |A| + |B| - |union(A, B)| > |B|, so is clamped below anyway.
+		intersection = bEstimate
+	case bEstimate <= unionEstimate-aEstimate:
+		return 0
+	default:
+		intersection = bEstimate - (unionEstimate - aEstimate)
+	}
+
+	if intersection > aEstimate {
+		intersection = aEstimate
+	}
+
+	if intersection > bEstimate {
+		intersection = bEstimate
+	}
+
+	return intersection
+}
@@ -0,0 +1,186 @@
+package hll
+
+import (
+	"math"
+	"testing"
+)
+
+// createOverlappingSketches returns sketches holding [0, size) and [size-overlap, 2*size-overlap).
+func createOverlappingSketches(t *testing.T, size, overlap int, sparse bool) (Sketch, Sketch) {
+	a, b := Sketch(createSketch()), Sketch(createSketch())
+
+	if sparse {
+		a, b = createSparseSketch(t), createSparseSketch(t)
+	}
+
+	for i := 0; i < size; i++ {
+		a.InsertUint64(uint64(i))
+		b.InsertUint64(uint64(size - overlap + i))
+	}
+
+	return a, b
+}
+
+func TestIntersectionEstimate(t *testing.T) {
+	a, b := createOverlappingSketches(t, 100_000, 50_000, false)
+	aRegisters := a.getRegisters()
+
+	intersection, err := IntersectionEstimate(a, b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The error is relative to the union (150,000), so allow 3% of that.
+	if math.Abs(float64(intersection)-50_000) > 0.03*150_000 {
+		t.Logf("intersection estimate - expected a cardinality close to: %d, got: %d", 50_000, intersection)
+		t.Fail()
+	}
+
+	for i, r := range a.getRegisters() {
+		if aRegisters[i] != r {
+			t.Fatalf("intersection estimate - expected sketch not to be modified, but register %d changed", i)
+		}
+	}
+}
+
+func TestIntersectionEstimate_Disjoint(t *testing.T) {
+	a, b := createOverlappingSketches(t, 100, 0, true)
+
+	intersection, err := IntersectionEstimate(a, b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if intersection != 0 {
+		t.Logf("intersection estimate - expected disjoint sketches to give: %d, got: %d", 0, intersection)
+		t.Fail()
+	}
+}
+
+func TestIntersectionEstimate_Saturated(t *testing.T) {
+	s := createEstimatorSketch(t, MinPrecision, EstimatorMLE, false)
+
+	for i := 0; i < s.registerCount(); i++ {
+		s.registers.set(uint64(i), maxRank)
+	}
+
+	intersection, err := IntersectionEstimate(s, s.Clone())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if intersection != s.Estimate() {
+		t.Logf("intersection estimate - expected identical saturated sketches to give: %d, got: %d", s.Estimate(), intersection)
+		t.Fail()
+	}
+}
+
+func TestIntersectionEstimate_Mismatched(t *testing.T) {
+	a := createSketch()
+	b := createSketchWithPrecision(DefaultPrecision - 1)
+
+	_, err := IntersectionEstimate(a, b)
+
+	if err != ErrorMalformedPrecision {
+		t.Logf("intersection estimate - expected different precisions to fail with: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+
+	b = createSketch()
+	b.version = "bad"
+
+	_, err = Jaccard(a, b)
+
+	if err != ErrorMismatchedVersion {
+		t.Logf("jaccard - expected different versions to fail with: %v, got: %v", ErrorMismatchedVersion, err)
+		t.Fail()
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	for _, sparse := range []bool{false, true} {
+		a, b := createOverlappingSketches(t, 1_000, 500, sparse)
+
+		jaccard, err := Jaccard(a, b)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 500 / 1,500
+		if math.Abs(jaccard-1.0/3) > 0.05 {
+			t.Logf("jaccard (sparse: %v) - expected an index close to: %.3f, got: %.3f", sparse, 1.0/3, jaccard)
+			t.Fail()
+		}
+
+		if sparse && (sparseOf(a) == nil || sparseOf(b) == nil) {
+			t.Logf("jaccard - expected sparse sketches to stay sparse, but did not")
+			t.Fail()
+		}
+	}
+}
+
+func TestJaccard_Empty(t *testing.T) {
+	jaccard, err := Jaccard(createSketch(), createSparseSketch(t))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jaccard != 0 {
+		t.Logf("jaccard - expected empty sketches to give: %v, got: %v", 0, jaccard)
+		t.Fail()
+	}
+}
+
+func TestUnionEstimate(t *testing.T) {
+	a, b := createOverlappingSketches(t, 100_000, 50_000, false)
+	c, _ := createOverlappingSketches(t, 100, 0, true)
+	aRegisters := a.getRegisters()
+
+	union, err := UnionEstimate(a, b, c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Rollup([]Sketch{a, b, c})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if union != expected.Estimate() {
+		t.Logf("union estimate - expected to match rollup estimate: %d, got: %d", expected.Estimate(), union)
+		t.Fail()
+	}
+
+	for i, r := range a.getRegisters() {
+		if aRegisters[i] != r {
+			t.Fatalf("union estimate - expected sketch not to be modified, but register %d changed", i)
+		}
+	}
+
+	if sparseOf(c) == nil {
+		t.Fatalf("union estimate - expected sparse sketch to stay sparse, but did not")
+	}
+}
+
+func TestUnionEstimate_Invalid(t *testing.T) {
+	_, err := UnionEstimate()
+
+	if err == nil {
+		t.Logf("union estimate - expected no sketches to error, but did not")
+		t.Fail()
+	}
+
+	_, err = UnionEstimate(createSketch(), createSketchWithPrecision(DefaultPrecision-1))
+
+	if err != ErrorMalformedPrecision {
+		t.Logf("union estimate - expected different precisions to fail with: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
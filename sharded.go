@@ -0,0 +1,253 @@
+package hll
+
+import (
+	"runtime"
+	"sync"
+
+	hllProto "github.com/kixa/hll-protobuf"
+)
+
+// ShardedSketch is a Sketch that is safe for concurrent use, and scales with the number of inserting goroutines.
+// Each P (roughly: core) inserts into its own shard (a separate set of registers), so cores don't contend on
+// the same cache lines. Shards are only combined (via Rollup) when a snapshot is needed, for estimates,
+// serialization or merging into other Sketches.
+//
+// Each shard uses as much memory as a Sketch with the same options, and there are up to GOMAXPROCS shards.
+type ShardedSketch struct {
+	// pool hands out shards, mostly to the same P that last put them back.
+	pool sync.Pool
+
+	// shards is every shard created, since pool can drop them at any time. (Dropped shards are picked up
+	// again once limit shards have been created).
+	shards []*shard
+	next   int
+	limit  int
+	mu     sync.Mutex
+
+	config *sketch
+}
+
+type shard struct {
+	mu sync.Mutex
+	s  *sketch
+}
+
+// NewShardedSketch returns a new ShardedSketch whose shards are configured by options, or
+// DefaultSketchOptions() if options is nil. An error is returned if options are invalid.
+func NewShardedSketch(options *SketchOptions) (*ShardedSketch, error) {
+	config, err := newConfigSketch(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// (Shards are only created as the pool hands them out, so every shard takes inserts).
+	ss := &ShardedSketch{
+		limit:  runtime.GOMAXPROCS(0),
+		config: config,
+	}
+
+	ss.pool.New = ss.newShard
+
+	return ss, nil
+}
+
+// newShard returns a new shard, or one of the existing shards once limit have been created.
+func (ss *ShardedSketch) newShard() interface{} {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if len(ss.shards) < ss.limit {
+		sh := &shard{s: ss.config.emptyCopy()}
+		ss.shards = append(ss.shards, sh)
+
+		return sh
+	}
+
+	// Either the pool dropped some shards (which could now be idle), or there are more goroutines inserting
+	// than Ps. Either way, shard locks keep sharing them safe.
+	sh := ss.shards[ss.next%len(ss.shards)]
+	ss.next += 1
+
+	return sh
+}
+
+// do calls fn with a shard to insert into.
+func (ss *ShardedSketch) do(fn func(s *sketch)) {
+	sh := ss.pool.Get().(*shard)
+
+	sh.mu.Lock()
+	fn(sh.s)
+	sh.mu.Unlock()
+
+	ss.pool.Put(sh)
+}
+
+// Insert inserts element into the Sketch.
+func (ss *ShardedSketch) Insert(element []byte) {
+	ss.do(func(s *sketch) {
+		s.Insert(element)
+	})
+}
+
+// InsertString inserts element into the Sketch.
+func (ss *ShardedSketch) InsertString(element string) {
+	ss.do(func(s *sketch) {
+		s.InsertString(element)
+	})
+}
+
+// InsertUint64 inserts the 8 byte little-endian encoding of element into the Sketch.
+func (ss *ShardedSketch) InsertUint64(element uint64) {
+	ss.do(func(s *sketch) {
+		s.InsertUint64(element)
+	})
+}
+
+// InsertHash inserts an already hashed element into the Sketch.
+func (ss *ShardedSketch) InsertHash(h uint64) {
+	ss.do(func(s *sketch) {
+		s.InsertHash(h)
+	})
+}
+
+// InsertMany inserts each of elements into the Sketch.
+func (ss *ShardedSketch) InsertMany(elements [][]byte) {
+	ss.do(func(s *sketch) {
+		s.InsertMany(elements)
+	})
+}
+
+// Snapshot returns a (non-concurrent) Sketch holding a consistent copy of every shard of ss, rolled up.
+func (ss *ShardedSketch) Snapshot() Sketch {
+	return ss.snapshot()
+}
+
+func (ss *ShardedSketch) snapshot() *sketch {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if len(ss.shards) == 0 {
+		return ss.config.emptyCopy()
+	}
+
+	sketches := make([]Sketch, len(ss.shards))
+
+	for i, sh := range ss.shards {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+
+		sketches[i] = sh.s
+	}
+
+	// (Shards are always compatible, so this can't error).
+	rolled, _ := Rollup(sketches)
+
+	s := rolled.(*sketch)
+	s.biasSet = ss.config.biasSet
+	s.estimator = ss.config.estimator
+
+	return s
+}
+
+// Estimate returns the estimated cardinality of a snapshot of ss.
+func (ss *ShardedSketch) Estimate() uint64 {
+	return ss.snapshot().Estimate()
+}
+
+// EstimateWith returns the estimated cardinality of a snapshot of ss, calculated using estimator.
+func (ss *ShardedSketch) EstimateWith(estimator Estimator) uint64 {
+	return ss.snapshot().EstimateWith(estimator)
+}
+
+// EstimateWithBounds returns the estimated cardinality of a snapshot of ss, along with its confidence interval.
+func (ss *ShardedSketch) EstimateWithBounds(confidence float64) (estimate, lower, upper uint64) {
+	return ss.snapshot().EstimateWithBounds(confidence)
+}
+
+// RelativeStandardError returns the relative standard error of estimates from this Sketch.
+func (ss *ShardedSketch) RelativeStandardError() float64 {
+	return ss.config.RelativeStandardError()
+}
+
+// snapshotter is implemented by the concurrent Sketches, which need to lock themselves to be read.
+type snapshotter interface {
+	snapshot() *sketch
+}
+
+// Merge merges other into (one shard of) ss, returning ss for convenience.
+func (ss *ShardedSketch) Merge(other Sketch) (Sketch, error) {
+	// Read other before locking a shard, since it could need to lock ss (or be merging ss into itself).
+	if sn, ok := other.(snapshotter); ok {
+		other = sn.snapshot()
+	}
+
+	var err error
+
+	ss.do(func(s *sketch) {
+		_, err = s.Merge(other)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+// Clone returns a new ShardedSketch holding a snapshot of ss.
+func (ss *ShardedSketch) Clone() Sketch {
+	clone := &ShardedSketch{
+		limit:  ss.limit,
+		config: ss.config,
+	}
+
+	clone.shards = []*shard{{s: ss.snapshot()}}
+	clone.pool.New = clone.newShard
+
+	return clone
+}
+
+// ProtoSketch returns a protobuf compatible version of a snapshot of ss.
+func (ss *ShardedSketch) ProtoSketch() *hllProto.Sketch {
+	return ss.snapshot().ProtoSketch()
+}
+
+// ProtoSerialize returns a snapshot of ss as protobuf []byte.
+func (ss *ShardedSketch) ProtoSerialize() ([]byte, error) {
+	return ss.snapshot().ProtoSerialize()
+}
+
+// MarshalBinary returns a snapshot of ss in the compact binary format, which can be read back (as a
+// non-concurrent Sketch) by UnmarshalBinary.
+func (ss *ShardedSketch) MarshalBinary() ([]byte, error) {
+	return ss.snapshot().MarshalBinary()
+}
+
+func (ss *ShardedSketch) getRegisters() []uint8 {
+	return ss.snapshot().getRegisters()
+}
+
+func (ss *ShardedSketch) getPrecision() uint8 {
+	return ss.config.precision
+}
+
+func (ss *ShardedSketch) getHasher() Hasher {
+	return ss.config.hasher
+}
+
+func (ss *ShardedSketch) getSeed() uint64 {
+	return ss.config.seed
+}
+
+func (ss *ShardedSketch) getSeedFingerprint() uint64 {
+	return ss.config.seedFingerprint
+}
+
+func (ss *ShardedSketch) getVersion() string {
+	return ss.config.version
+}
+
+func (ss *ShardedSketch) emptyCopy() *sketch {
+	return ss.config.emptyCopy()
+}
@@ -0,0 +1,231 @@
+package hll
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func createShardedSketch(t testing.TB, options *SketchOptions) *ShardedSketch {
+	ss, err := NewShardedSketch(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ss
+}
+
+func TestShardedSketch_MatchesSketch(t *testing.T) {
+	rand.Seed(0)
+
+	elements := make([][]byte, 100_000)
+
+	for i := range elements {
+		elements[i] = []byte(genPseudoRandomStr())
+	}
+
+	expected := createSketch()
+	expected.InsertMany(elements)
+
+	for _, sparse := range []bool{false, true} {
+		options := DefaultSketchOptions()
+		options.Sparse = sparse
+
+		ss := createShardedSketch(t, options)
+
+		const goroutines = 16
+		var wg sync.WaitGroup
+
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+
+			go func(g int) {
+				defer wg.Done()
+
+				for i := g; i < len(elements); i += goroutines {
+					ss.Insert(elements[i])
+
+					if i%10_000 == 0 {
+						ss.Estimate()
+					}
+				}
+			}(g)
+		}
+
+		wg.Wait()
+
+		if ss.Estimate() != expected.Estimate() {
+			t.Logf("sharded sketch (sparse: %v) - expected estimate: %d, got: %d", sparse, expected.Estimate(), ss.Estimate())
+			t.Fail()
+		}
+
+		registers := ss.getRegisters()
+
+		for i, r := range expected.getRegisters() {
+			if registers[i] != r {
+				t.Fatalf("sharded sketch (sparse: %v) - register %d expected: %d, got: %d", sparse, i, r, registers[i])
+			}
+		}
+	}
+}
+
+func TestShardedSketch_Merge(t *testing.T) {
+	rand.Seed(0)
+
+	ss := createShardedSketch(t, nil)
+	c := createConcurrentSketch(t)
+	s := createSketch()
+
+	for i := 0; i < 50_000; i++ {
+		ss.Insert([]byte(genPseudoRandomStr()))
+		c.Insert([]byte(genPseudoRandomStr()))
+	}
+
+	// Into a Sketch...
+	if _, err := s.Merge(ss); err != nil {
+		t.Fatal(err)
+	}
+
+	if !acceptableEstimate(50_000, s.Estimate()) {
+		t.Logf("sharded sketch merge - expected a cardinality +/-3%% of: %d, got: %d", 50_000, s.Estimate())
+		t.Fail()
+	}
+
+	// ...and into a ShardedSketch (including itself).
+	for _, other := range []Sketch{c, ss} {
+		if _, err := ss.Merge(other); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !acceptableEstimate(100_000, ss.Estimate()) {
+		t.Logf("sharded sketch merge - expected a cardinality +/-3%% of: %d, got: %d", 100_000, ss.Estimate())
+		t.Fail()
+	}
+
+	_, err := ss.Merge(createSketchWithPrecision(DefaultPrecision - 1))
+
+	if err != ErrorMalformedPrecision {
+		t.Logf("sharded sketch merge - expected different precisions to fail with: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
+
+func TestShardedSketch_Config(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Estimator = EstimatorMLE
+	options.Seed = 1
+
+	ss := createShardedSketch(t, options)
+
+	for i := 0; i < 1_000; i++ {
+		ss.InsertUint64(uint64(i))
+	}
+
+	s := ss.Snapshot().(*sketch)
+
+	if s.estimator != EstimatorMLE || s.seed != 1 {
+		t.Logf("sharded sketch - expected snapshot estimator: %v (seed: %d), got: %v (seed: %d)", EstimatorMLE, 1, s.estimator, s.seed)
+		t.Fail()
+	}
+
+	clone := ss.Clone()
+	clone.InsertUint64(1_000)
+
+	if ss.Estimate() == clone.Estimate() {
+		t.Logf("sharded sketch clone - expected inserting into clone not to affect estimate: %d", ss.Estimate())
+		t.Fail()
+	}
+}
+
+func TestShardedSketch_Shards(t *testing.T) {
+	ss := createShardedSketch(t, nil)
+
+	if len(ss.shards) != 0 || ss.Estimate() != 0 {
+		t.Fatalf("sharded sketch - expected no shards (and estimate: %d) before inserts, got: %d (estimate: %d)", 0, len(ss.shards), ss.Estimate())
+	}
+
+	for i := 0; i < 1_000; i++ {
+		ss.InsertUint64(uint64(i))
+	}
+
+	// Every shard should have been handed out to take inserts.
+	for i, sh := range ss.shards {
+		if sh.s.Estimate() == 0 {
+			t.Logf("sharded sketch - expected shard %d (of %d) to have taken inserts, but it is empty", i, len(ss.shards))
+			t.Fail()
+		}
+	}
+}
+
+// Benchmarks: a plain Sketch (single goroutine, and shared behind a mutex) vs ConcurrentSketch and ShardedSketch.
+
+func BenchmarkSketch_Insert(b *testing.B) {
+	s := createSketch()
+
+	for i := 0; i < b.N; i++ {
+		s.InsertUint64(uint64(i))
+	}
+}
+
+func BenchmarkSketch_InsertParallelMutex(b *testing.B) {
+	s := createSketch()
+	var mu sync.Mutex
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Uint64()
+
+		for pb.Next() {
+			mu.Lock()
+			s.InsertUint64(i)
+			mu.Unlock()
+
+			i++
+		}
+	})
+}
+
+func BenchmarkConcurrentSketch_InsertParallel(b *testing.B) {
+	c, err := NewConcurrentSketch(nil)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Uint64()
+
+		for pb.Next() {
+			c.InsertUint64(i)
+			i++
+		}
+	})
+}
+
+func BenchmarkShardedSketch_InsertParallel(b *testing.B) {
+	ss := createShardedSketch(b, nil)
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Uint64()
+
+		for pb.Next() {
+			ss.InsertUint64(i)
+			i++
+		}
+	})
+}
+
+func BenchmarkShardedSketch_Estimate(b *testing.B) {
+	ss := createShardedSketch(b, nil)
+
+	for i := 0; i < 100_000; i++ {
+		ss.InsertUint64(uint64(i))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ss.Estimate()
+	}
+}
@@ -0,0 +1,443 @@
+package hll
+
+import (
+	"container/heap"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// EvictionPolicy selects which key a SketchMap evicts once it's over its memory budget.
+type EvictionPolicy int
+
+const (
+	// EvictLeastRecentlyUsed evicts the key least recently inserted into, merged into, estimated or snapshotted.
+	EvictLeastRecentlyUsed EvictionPolicy = iota
+
+	// EvictLeastRecentlyUpdated evicts the key least recently inserted into or merged into, regardless of reads.
+	EvictLeastRecentlyUpdated
+)
+
+func (e EvictionPolicy) valid() bool {
+	return e >= EvictLeastRecentlyUsed && e <= EvictLeastRecentlyUpdated
+}
+
+func (e EvictionPolicy) String() string {
+	switch e {
+	case EvictLeastRecentlyUsed:
+		return "least_recently_used"
+	case EvictLeastRecentlyUpdated:
+		return "least_recently_updated"
+	}
+
+	return fmt.Sprintf("EvictionPolicy(%d)", int(e))
+}
+
+// sketchMapKeyOverhead is the (approximate) number of bytes used to track each key of a SketchMap, on top of
+// the key itself and its Sketch's registers.
+const sketchMapKeyOverhead = 128
+
+// SketchMapOptions contains parameters used for NewSketchMap.
+type SketchMapOptions struct {
+	// SketchOptions configures the Sketch of each key. If nil, DefaultSketchOptions() is used. (Sparse
+	// Sketches make many low cardinality keys much cheaper).
+	SketchOptions *SketchOptions
+
+	// MemoryBudget is the (approximate) number of bytes the SketchMap may use, counting each key, its Sketch's
+	// registers and some bookkeeping. Once over budget, keys are evicted (per Eviction) until it's back under.
+	// If 0, the SketchMap is unbounded.
+	MemoryBudget int
+
+	// Eviction selects which keys are evicted first. Defaults to EvictLeastRecentlyUsed.
+	Eviction EvictionPolicy
+
+	// OnEvict, if set, is called with each evicted key and its Sketch (e.g. to persist it). It is called
+	// without the SketchMap locked, so may use it.
+	OnEvict func(key string, s Sketch)
+}
+
+// SketchMap holds a Sketch per string key (e.g. distinct users per customer), within a memory budget.
+// SketchMaps are safe for concurrent use.
+type SketchMap struct {
+	// entries indexes order, which runs from the most to the least recently used (or updated) key.
+	entries map[string]*list.Element
+	order   *list.List
+
+	size     int
+	budget   int
+	eviction EvictionPolicy
+	onEvict  func(key string, s Sketch)
+
+	mu sync.Mutex
+
+	config *sketch
+}
+
+type sketchMapEntry struct {
+	key  string
+	s    *sketch
+	size int
+
+	// estimate caches the estimate of s while estimated is set, which is cleared whenever s may have changed.
+	estimate  uint64
+	estimated bool
+}
+
+// getEstimate returns the (cached) estimate of e.
+func (e *sketchMapEntry) getEstimate() uint64 {
+	if !e.estimated {
+		e.estimate = e.s.Estimate()
+		e.estimated = true
+	}
+
+	return e.estimate
+}
+
+// NewSketchMap returns a new, empty SketchMap configured by options (the defaults if options is nil). An error
+// is returned if options are invalid.
+func NewSketchMap(options *SketchMapOptions) (*SketchMap, error) {
+	if options == nil {
+		options = &SketchMapOptions{}
+	}
+
+	if options.MemoryBudget < 0 {
+		return nil, fmt.Errorf("invalid options: memory budget %d must not be negative", options.MemoryBudget)
+	}
+
+	if !options.Eviction.valid() {
+		return nil, fmt.Errorf("invalid options: unknown eviction policy %v", options.Eviction)
+	}
+
+	config, err := newConfigSketch(options.SketchOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SketchMap{
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		budget:   options.MemoryBudget,
+		eviction: options.Eviction,
+		onEvict:  options.OnEvict,
+		config:   config,
+	}, nil
+}
+
+// Insert inserts element into the Sketch of key.
+func (sm *SketchMap) Insert(key string, element []byte) {
+	sm.update(key, func(s *sketch) (bool, error) {
+		return s.addHash(s.hash(element)), nil
+	})
+}
+
+// InsertString inserts element into the Sketch of key.
+func (sm *SketchMap) InsertString(key string, element string) {
+	sm.update(key, func(s *sketch) (bool, error) {
+		return s.addHash(s.hash(stringBytes(element))), nil
+	})
+}
+
+// InsertUint64 inserts the 8 byte little-endian encoding of element into the Sketch of key.
+func (sm *SketchMap) InsertUint64(key string, element uint64) {
+	sm.update(key, func(s *sketch) (bool, error) {
+		binary.LittleEndian.PutUint64(s.buf[:], element)
+		return s.addHash(s.hash(s.buf[:])), nil
+	})
+}
+
+// InsertHash inserts an already hashed element into the Sketch of key.
+func (sm *SketchMap) InsertHash(key string, h uint64) {
+	sm.update(key, func(s *sketch) (bool, error) {
+		return s.addHash(h), nil
+	})
+}
+
+// Merge merges other into the Sketch of key (creating it if needed). It will error if other can't be merged
+// into Sketches of sm.
+func (sm *SketchMap) Merge(key string, other Sketch) error {
+	// Read other before locking sm, since it could need to lock itself (or be a snapshot of sm).
+	if sn, ok := other.(snapshotter); ok {
+		other = sn.snapshot()
+	}
+
+	if err := checkMergeable(sm.config, other); err != nil {
+		return err
+	}
+
+	return sm.update(key, func(s *sketch) (bool, error) {
+		_, err := s.Merge(other)
+		return true, err
+	})
+}
+
+// MergeMap merges the Sketch of each key of other into the Sketch of the same key of sm. It will error (without
+// merging anything) if Sketches of other can't be merged into Sketches of sm.
+func (sm *SketchMap) MergeMap(other *SketchMap) error {
+	if err := checkMergeable(sm.config, other.config); err != nil {
+		return err
+	}
+
+	// (Snapshotting other first means it's never locked at the same time as sm, even when merging sm into itself).
+	for key, s := range other.SnapshotAll() {
+		err := sm.update(key, func(into *sketch) (bool, error) {
+			_, err := into.Merge(s)
+			return true, err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Estimate returns the estimated cardinality of key, and whether key is held (it may never have been inserted
+// into, or been evicted).
+func (sm *SketchMap) Estimate(key string) (uint64, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	e := sm.get(key)
+
+	if e == nil {
+		return 0, false
+	}
+
+	return e.getEstimate(), true
+}
+
+// Snapshot returns a copy of the Sketch of key, and whether key is held.
+func (sm *SketchMap) Snapshot(key string) (Sketch, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	e := sm.get(key)
+
+	if e == nil {
+		return nil, false
+	}
+
+	return e.s.Clone(), true
+}
+
+// SnapshotAll returns a copy of the Sketch of every key held. Unlike Snapshot, this doesn't count as a use of
+// any key.
+func (sm *SketchMap) SnapshotAll() map[string]Sketch {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	snapshot := make(map[string]Sketch, len(sm.entries))
+
+	for key, el := range sm.entries {
+		snapshot[key] = el.Value.(*sketchMapEntry).s.Clone()
+	}
+
+	return snapshot
+}
+
+// Delete removes key (and its Sketch) from sm, returning whether it was held. OnEvict is not called.
+func (sm *SketchMap) Delete(key string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	el, ok := sm.entries[key]
+
+	if ok {
+		sm.remove(el)
+	}
+
+	return ok
+}
+
+// Keys returns every key held, from the most to the least recently used (or updated, per the eviction policy).
+func (sm *SketchMap) Keys() []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	keys := make([]string, 0, len(sm.entries))
+
+	for el := sm.order.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*sketchMapEntry).key)
+	}
+
+	return keys
+}
+
+// Len returns the number of keys held.
+func (sm *SketchMap) Len() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return len(sm.entries)
+}
+
+// Size returns the (approximate) number of bytes used by sm, as counted against its memory budget.
+func (sm *SketchMap) Size() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.size
+}
+
+// get returns the entry of key (counting this as a use), or nil if it isn't held. sm must be locked.
+func (sm *SketchMap) get(key string) *sketchMapEntry {
+	el, ok := sm.entries[key]
+
+	if !ok {
+		return nil
+	}
+
+	if sm.eviction == EvictLeastRecentlyUsed {
+		sm.order.MoveToFront(el)
+	}
+
+	return el.Value.(*sketchMapEntry)
+}
+
+// update calls fn with the sketch of key (creating it if needed), then evicts keys until sm is within budget.
+// fn returns whether it may have changed the sketch.
+func (sm *SketchMap) update(key string, fn func(s *sketch) (bool, error)) error {
+	sm.mu.Lock()
+
+	el, ok := sm.entries[key]
+
+	if ok {
+		sm.order.MoveToFront(el)
+	} else {
+		el = sm.order.PushFront(&sketchMapEntry{
+			key:  key,
+			s:    sm.config.emptyCopy(),
+			size: len(key) + sketchMapKeyOverhead,
+		})
+
+		sm.entries[key] = el
+		sm.size += el.Value.(*sketchMapEntry).size
+	}
+
+	e := el.Value.(*sketchMapEntry)
+	changed, err := fn(e.s)
+
+	if changed {
+		e.estimated = false
+	}
+
+	// (Sketches only change size when inserted into or merged, so sizes only need updating here).
+	size := len(key) + sketchMapKeyOverhead + e.s.size()
+	sm.size += size - e.size
+	e.size = size
+
+	evicted := sm.evict()
+
+	sm.mu.Unlock()
+
+	if sm.onEvict != nil {
+		for _, e := range evicted {
+			sm.onEvict(e.key, e.s)
+		}
+	}
+
+	return err
+}
+
+// evict removes the least recently used (or updated) keys until sm is within budget, returning them. The most
+// recent key is never evicted, even if it alone is over budget. sm must be locked.
+func (sm *SketchMap) evict() []*sketchMapEntry {
+	var evicted []*sketchMapEntry
+
+	for sm.budget > 0 && sm.size > sm.budget && sm.order.Len() > 1 {
+		evicted = append(evicted, sm.remove(sm.order.Back()))
+	}
+
+	return evicted
+}
+
+// remove removes el from sm, returning its entry. sm must be locked.
+func (sm *SketchMap) remove(el *list.Element) *sketchMapEntry {
+	e := sm.order.Remove(el).(*sketchMapEntry)
+
+	delete(sm.entries, e.key)
+	sm.size -= e.size
+
+	return e
+}
+
+// KeyEstimate is a key of a SketchMap, along with its estimated cardinality.
+type KeyEstimate struct {
+	Key      string
+	Estimate uint64
+}
+
+// TopK returns (up to) the k keys with the largest estimated cardinality, largest first (ties are ordered
+// by key). Like SnapshotAll, this doesn't count as a use of any key.
+//
+// Estimates are cached per key until it's next inserted into (and an insert actually raises a register) or
+// merged into, so repeated queries only re-estimate keys that have changed.
+func (sm *SketchMap) TopK(k int) []KeyEstimate {
+	if k <= 0 {
+		return nil
+	}
+
+	sm.mu.Lock()
+
+	// h holds the top k seen so far, with the smallest at its root.
+	h := make(keyEstimateHeap, 0, k)
+
+	for key, el := range sm.entries {
+		ke := KeyEstimate{Key: key, Estimate: el.Value.(*sketchMapEntry).getEstimate()}
+
+		if len(h) < k {
+			heap.Push(&h, ke)
+		} else if h.less(h[0], ke) {
+			h[0] = ke
+			heap.Fix(&h, 0)
+		}
+	}
+
+	sm.mu.Unlock()
+
+	sort.Slice(h, func(i, j int) bool {
+		return h.less(h[j], h[i])
+	})
+
+	return h
+}
+
+// keyEstimateHeap is a min-heap of KeyEstimates, for TopK.
+type keyEstimateHeap []KeyEstimate
+
+// less orders a and b by estimate, then (for a consistent order) by reverse key.
+func (h keyEstimateHeap) less(a, b KeyEstimate) bool {
+	if a.Estimate != b.Estimate {
+		return a.Estimate < b.Estimate
+	}
+
+	return a.Key > b.Key
+}
+
+func (h keyEstimateHeap) Len() int {
+	return len(h)
+}
+
+func (h keyEstimateHeap) Less(i, j int) bool {
+	return h.less(h[i], h[j])
+}
+
+func (h keyEstimateHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *keyEstimateHeap) Push(x interface{}) {
+	*h = append(*h, x.(KeyEstimate))
+}
+
+func (h *keyEstimateHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return x
+}
@@ -0,0 +1,375 @@
+package hll
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func createSketchMap(t *testing.T, options *SketchMapOptions) *SketchMap {
+	sm, err := NewSketchMap(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sm
+}
+
+func TestSketchMap_Estimate(t *testing.T) {
+	rand.Seed(0)
+
+	sm := createSketchMap(t, nil)
+	expected := map[string]uint64{"a": 10, "b": 1_000, "c": 50_000}
+
+	for key, n := range expected {
+		for i := uint64(0); i < n; i++ {
+			sm.Insert(key, []byte(genPseudoRandomStr()))
+		}
+	}
+
+	for key, n := range expected {
+		estimate, ok := sm.Estimate(key)
+
+		if !ok || !acceptableEstimate(n, estimate) {
+			t.Logf("sketch map %s - expected a cardinality +/-3%% of: %d, got: %d (held: %v)", key, n, estimate, ok)
+			t.Fail()
+		}
+	}
+
+	if estimate, ok := sm.Estimate("missing"); ok || estimate != 0 {
+		t.Logf("sketch map - expected missing key not to be held, got: %d (held: %v)", estimate, ok)
+		t.Fail()
+	}
+
+	if sm.Len() != 3 {
+		t.Logf("sketch map - expected length: %d, got: %d", 3, sm.Len())
+		t.Fail()
+	}
+}
+
+func TestSketchMap_Eviction(t *testing.T) {
+	tests := []struct {
+		eviction EvictionPolicy
+		evicted  string
+	}{
+		{EvictLeastRecentlyUsed, "b"},
+		{EvictLeastRecentlyUpdated, "a"},
+	}
+
+	for _, test := range tests {
+		options := DefaultSketchOptions()
+		options.Precision = 10
+
+		// Enough for 3 (dense) keys.
+		perKey := 1<<10 + 1 + sketchMapKeyOverhead
+		var evicted []string
+
+		sm := createSketchMap(t, &SketchMapOptions{
+			SketchOptions: options,
+			MemoryBudget:  3 * perKey,
+			Eviction:      test.eviction,
+			OnEvict: func(key string, s Sketch) {
+				evicted = append(evicted, key)
+			},
+		})
+
+		for _, key := range []string{"a", "b", "c"} {
+			sm.InsertString(key, "test")
+		}
+
+		// Reading a only saves it from least recently used eviction.
+		sm.Estimate("a")
+		sm.InsertString("d", "test")
+
+		if len(evicted) != 1 || evicted[0] != test.evicted {
+			t.Logf("sketch map eviction (%v) - expected evicted: [%s], got: %v", test.eviction, test.evicted, evicted)
+			t.Fail()
+		}
+
+		if _, ok := sm.Estimate(test.evicted); ok || sm.Len() != 3 {
+			t.Logf("sketch map eviction (%v) - expected %s evicted (leaving %d keys), got: %v", test.eviction, test.evicted, 3, sm.Keys())
+			t.Fail()
+		}
+
+		if sm.Size() != 3*perKey {
+			t.Logf("sketch map eviction (%v) - expected size: %d, got: %d", test.eviction, 3*perKey, sm.Size())
+			t.Fail()
+		}
+	}
+}
+
+func TestSketchMap_SparseBudget(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Sparse = true
+
+	const budget = 64 * 1024
+	sm := createSketchMap(t, &SketchMapOptions{SketchOptions: options, MemoryBudget: budget})
+
+	// Sparse keys are cheap, so far more than budget / 16KB fit...
+	for k := 0; k < 100; k++ {
+		for i := 0; i < 10; i++ {
+			sm.InsertUint64(fmt.Sprint(k), uint64(i))
+		}
+	}
+
+	if sm.Len() != 100 {
+		t.Logf("sketch map sparse - expected length: %d, got: %d", 100, sm.Len())
+		t.Fail()
+	}
+
+	// ...until they grow.
+	for i := 0; i < 100_000; i++ {
+		sm.InsertUint64("large", uint64(i))
+	}
+
+	if sm.Size() > budget {
+		t.Logf("sketch map sparse - expected size within: %d, got: %d", budget, sm.Size())
+		t.Fail()
+	}
+
+	if estimate, ok := sm.Estimate("large"); !ok || !acceptableEstimate(100_000, estimate) {
+		t.Logf("sketch map sparse - expected a cardinality +/-3%% of: %d, got: %d (held: %v)", 100_000, estimate, ok)
+		t.Fail()
+	}
+
+	expected := 0
+
+	for key, s := range sm.SnapshotAll() {
+		expected += len(key) + sketchMapKeyOverhead + s.(*sketch).size()
+	}
+
+	if sm.Size() != expected {
+		t.Logf("sketch map sparse - expected size: %d, got: %d", expected, sm.Size())
+		t.Fail()
+	}
+}
+
+func TestSketchMap_Merge(t *testing.T) {
+	sm := createSketchMap(t, nil)
+	other := createSketchMap(t, nil)
+
+	for i := 0; i < 1_000; i++ {
+		sm.InsertUint64("a", uint64(i))
+		other.InsertUint64("a", uint64(i+500))
+		other.InsertUint64("b", uint64(i))
+	}
+
+	s := createSketch()
+	s.InsertString("test")
+
+	if err := sm.Merge("c", s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sm.MergeMap(other); err != nil {
+		t.Fatal(err)
+	}
+
+	// (Merging sm into itself shouldn't change, or deadlock, anything).
+	if err := sm.MergeMap(sm); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]uint64{"a": 1_500, "b": 1_000, "c": 1}
+
+	for key, n := range expected {
+		estimate, ok := sm.Estimate(key)
+
+		if !ok || !acceptableEstimate(n, estimate) {
+			t.Logf("sketch map merge %s - expected a cardinality +/-3%% of: %d, got: %d (held: %v)", key, n, estimate, ok)
+			t.Fail()
+		}
+	}
+
+	snapshot, _ := sm.Snapshot("a")
+	snapshot.InsertString("other")
+
+	if estimate, _ := sm.Estimate("a"); estimate == snapshot.Estimate() {
+		t.Logf("sketch map snapshot - expected inserting into snapshot not to affect estimate: %d", estimate)
+		t.Fail()
+	}
+
+	if err := sm.Merge("a", createSketchWithPrecision(DefaultPrecision-1)); err != ErrorMalformedPrecision {
+		t.Logf("sketch map merge - expected different precisions to fail with: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+
+	options := DefaultSketchOptions()
+	options.Seed = 1
+
+	if err := sm.MergeMap(createSketchMap(t, &SketchMapOptions{SketchOptions: options})); err != ErrorMismatchedSeed {
+		t.Logf("sketch map merge map - expected different seeds to fail with: %v, got: %v", ErrorMismatchedSeed, err)
+		t.Fail()
+	}
+}
+
+func TestSketchMap_Delete(t *testing.T) {
+	sm := createSketchMap(t, nil)
+	sm.InsertString("a", "test")
+
+	if !sm.Delete("a") || sm.Delete("a") {
+		t.Logf("sketch map delete - expected key to be deleted once")
+		t.Fail()
+	}
+
+	if sm.Len() != 0 || sm.Size() != 0 {
+		t.Logf("sketch map delete - expected length: %d (size: %d), got: %d (size: %d)", 0, 0, sm.Len(), sm.Size())
+		t.Fail()
+	}
+}
+
+func TestSketchMap_Concurrent(t *testing.T) {
+	sm := createSketchMap(t, nil)
+
+	const goroutines = 8
+	var wg sync.WaitGroup
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 10_000; i++ {
+				sm.InsertUint64(fmt.Sprint(i%4), uint64(g*10_000+i))
+
+				if i%1_000 == 0 {
+					sm.Estimate("0")
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	for _, key := range sm.Keys() {
+		if estimate, _ := sm.Estimate(key); !acceptableEstimate(20_000, estimate) {
+			t.Logf("sketch map concurrent %s - expected a cardinality +/-3%% of: %d, got: %d", key, 20_000, estimate)
+			t.Fail()
+		}
+	}
+}
+
+func TestNewSketchMap_Invalid(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Precision = MaxPrecision + 1
+
+	invalid := []*SketchMapOptions{
+		{MemoryBudget: -1},
+		{Eviction: EvictLeastRecentlyUpdated + 1},
+		{SketchOptions: options},
+	}
+
+	for _, o := range invalid {
+		if _, err := NewSketchMap(o); err == nil {
+			t.Logf("new sketch map - expected invalid options to error: %+v", o)
+			t.Fail()
+		}
+	}
+}
+
+func TestSketchMap_TopK(t *testing.T) {
+	sm := createSketchMap(t, nil)
+
+	// Key i has 100 * i uniques.
+	for k := 1; k <= 50; k++ {
+		for i := 0; i < 100*k; i++ {
+			sm.InsertUint64(fmt.Sprint(k), uint64(i))
+		}
+	}
+
+	top := sm.TopK(5)
+
+	if len(top) != 5 {
+		t.Fatalf("sketch map top k - expected %d keys, got: %v", 5, top)
+	}
+
+	for i, ke := range top {
+		expected := fmt.Sprint(50 - i)
+		estimate, _ := sm.Estimate(expected)
+
+		if ke.Key != expected || ke.Estimate != estimate {
+			t.Logf("sketch map top k - expected %d: %s (estimate: %d), got: %s (estimate: %d)", i, expected, estimate, ke.Key, ke.Estimate)
+			t.Fail()
+		}
+	}
+
+	if len(sm.TopK(100)) != 50 || sm.TopK(0) != nil {
+		t.Logf("sketch map top k - expected at most every key (and none for k = 0)")
+		t.Fail()
+	}
+
+	// Raising a key's registers invalidates its cached estimate.
+	for i := 0; i < 100_000; i++ {
+		sm.InsertUint64("1", uint64(i))
+	}
+
+	if top := sm.TopK(1); top[0].Key != "1" {
+		t.Logf("sketch map top k - expected top key after inserts: %s, got: %v", "1", top)
+		t.Fail()
+	}
+}
+
+func TestSketchMap_CachedEstimate(t *testing.T) {
+	sm := createSketchMap(t, nil)
+
+	for i := 0; i < 1_000; i++ {
+		sm.InsertUint64("a", uint64(i))
+	}
+
+	estimate, _ := sm.Estimate("a")
+	e := sm.entries["a"].Value.(*sketchMapEntry)
+
+	// Re-inserting the same elements can't raise any registers, so shouldn't invalidate the estimate...
+	for i := 0; i < 1_000; i++ {
+		sm.InsertUint64("a", uint64(i))
+	}
+
+	if !e.estimated || e.estimate != estimate {
+		t.Logf("sketch map cached estimate - expected duplicates to keep cached estimate: %d, got: %d (cached: %v)", estimate, e.estimate, e.estimated)
+		t.Fail()
+	}
+
+	// ...but new elements (and merges) should.
+	sm.InsertUint64("a", 1_000)
+
+	if e.estimated {
+		t.Logf("sketch map cached estimate - expected new element to invalidate cached estimate")
+		t.Fail()
+	}
+
+	sm.Estimate("a")
+
+	if err := sm.Merge("a", createSketch()); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.estimated {
+		t.Logf("sketch map cached estimate - expected merge to invalidate cached estimate")
+		t.Fail()
+	}
+}
+
+func BenchmarkSketchMap_TopK(b *testing.B) {
+	sm, err := NewSketchMap(nil)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for k := 0; k < 1_000; k++ {
+		for i := 0; i < 100; i++ {
+			sm.InsertUint64(fmt.Sprint(k), uint64(k*i))
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// (A trickle of inserts between queries, as between polls of a live SketchMap).
+		sm.InsertUint64(fmt.Sprint(i%1_000), uint64(i))
+		sm.TopK(20)
+	}
+}
@@ -0,0 +1,154 @@
+package hll
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Entries are packed as: register << rankBits | rank, so sorting entries sorts by register first, and for
+// duplicate registers, the highest rank sorts last. (Ranks never exceed maxRank, so fit in rankBits).
+const rankBits = 6
+
+// maxSparseBuffer is the number of inserts buffered before they're folded into the sorted list.
+const maxSparseBuffer = 128
+
+// sparseRegisters is a HyperLogLog++ style sparse representation of a sketch's registers. Only touched
+// registers are stored, as a sorted list of delta-encoded (varint) entries. Recent inserts are buffered
+// (unsorted) in tmp, and folded into list in batches to amortise the cost of re-encoding.
+type sparseRegisters struct {
+	list []byte
+	n    int
+
+	tmp []uint32
+}
+
+func newSparseRegisters() *sparseRegisters {
+	return &sparseRegisters{}
+}
+
+func (sp *sparseRegisters) insert(register uint64, rank uint8) {
+	sp.tmp = append(sp.tmp, uint32(register)<<rankBits|uint32(rank))
+
+	if len(sp.tmp) >= maxSparseBuffer {
+		sp.flush()
+	}
+}
+
+// size returns the (approximate) number of bytes used to hold entries.
+func (sp *sparseRegisters) size() int {
+	return len(sp.list) + 4*len(sp.tmp)
+}
+
+// flush sorts tmp and merges it into list, keeping only the highest rank for each register.
+func (sp *sparseRegisters) flush() {
+	if len(sp.tmp) <= 0 {
+		return
+	}
+
+	sort.Slice(sp.tmp, func(i, j int) bool { return sp.tmp[i] < sp.tmp[j] })
+
+	merged := make([]byte, 0, len(sp.list)+binary.MaxVarintLen32*len(sp.tmp))
+	n := 0
+
+	var prev uint32
+	var pending uint32
+	hasPending := false
+
+	// Entries arrive in ascending order, so a later entry for the same register always has a higher rank.
+	emit := func(entry uint32) {
+		if hasPending && entry>>rankBits != pending>>rankBits {
+			merged = appendUvarint(merged, uint64(pending-prev))
+			prev = pending
+			n += 1
+		}
+
+		pending = entry
+		hasPending = true
+	}
+
+	ti := 0
+	sp.forEachListed(func(entry uint32) {
+		for ti < len(sp.tmp) && sp.tmp[ti] < entry {
+			emit(sp.tmp[ti])
+			ti += 1
+		}
+
+		emit(entry)
+	})
+
+	for ; ti < len(sp.tmp); ti++ {
+		emit(sp.tmp[ti])
+	}
+
+	if hasPending {
+		merged = appendUvarint(merged, uint64(pending-prev))
+		n += 1
+	}
+
+	sp.list = merged
+	sp.n = n
+	sp.tmp = sp.tmp[:0]
+}
+
+// forEachListed calls fn with each entry in list (ignoring anything still buffered in tmp), in ascending order.
+func (sp *sparseRegisters) forEachListed(fn func(entry uint32)) {
+	var entry uint32
+
+	for i := 0; i < len(sp.list); {
+		delta, read := binary.Uvarint(sp.list[i:])
+		i += read
+
+		entry += uint32(delta)
+		fn(entry)
+	}
+}
+
+// forEach calls fn with each touched register and its rank, in ascending order of register.
+func (sp *sparseRegisters) forEach(fn func(register uint32, rank uint8)) {
+	sp.flush()
+
+	sp.forEachListed(func(entry uint32) {
+		fn(entry>>rankBits, uint8(entry&(1<<rankBits-1)))
+	})
+}
+
+// merge folds other into sp.
+func (sp *sparseRegisters) merge(other *sparseRegisters) {
+	other.flush()
+	other.forEachListed(func(entry uint32) {
+		sp.tmp = append(sp.tmp, entry)
+	})
+
+	sp.flush()
+}
+
+// count returns the number of touched registers.
+func (sp *sparseRegisters) count() int {
+	sp.flush()
+	return sp.n
+}
+
+// clone returns a deep copy of sp.
+func (sp *sparseRegisters) clone() *sparseRegisters {
+	return &sparseRegisters{
+		list: append([]byte(nil), sp.list...),
+		n:    sp.n,
+		tmp:  append([]uint32(nil), sp.tmp...),
+	}
+}
+
+// toDense writes each touched register into registers.
+func (sp *sparseRegisters) toDense(registers registerArray) {
+	sp.forEach(func(register uint32, rank uint8) {
+		if registers.get(uint64(register)) < rank {
+			registers.set(uint64(register), rank)
+		}
+	})
+}
+
+func appendUvarint(bs []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+
+	return append(bs, buf[:n]...)
+}
@@ -0,0 +1,199 @@
+package hll
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSparseRegisters_Flush(t *testing.T) {
+	sp := newSparseRegisters()
+
+	sp.insert(10, 2)
+	sp.insert(3, 1)
+	sp.insert(10, 5)
+	sp.insert(10, 4)
+	sp.insert(7, 3)
+
+	expected := map[uint32]uint8{3: 1, 7: 3, 10: 5}
+
+	if sp.count() != len(expected) {
+		t.Fatalf("sparse flush - expected %d registers, got: %d", len(expected), sp.count())
+	}
+
+	previous := int64(-1)
+
+	sp.forEach(func(register uint32, rank uint8) {
+		if int64(register) <= previous {
+			t.Logf("sparse flush - register %d is not after previous (%d)", register, previous)
+			t.Fail()
+		}
+
+		if expected[register] != rank {
+			t.Logf("sparse flush - register %d expected rank: %d, got: %d", register, expected[register], rank)
+			t.Fail()
+		}
+
+		previous = int64(register)
+	})
+}
+
+func TestSparseRegisters_Merge(t *testing.T) {
+	sp0 := newSparseRegisters()
+	sp0.insert(1, 1)
+	sp0.insert(2, 4)
+
+	sp1 := newSparseRegisters()
+	sp1.insert(2, 2)
+	sp1.insert(3, 3)
+
+	sp0.merge(sp1)
+
+	registers := make(byteRegisters, 4)
+	sp0.toDense(registers)
+
+	expected := []uint8{0, 1, 4, 3}
+
+	for i := range expected {
+		if registers[i] != expected[i] {
+			t.Logf("sparse merge - register %d expected rank: %d, got: %d", i, expected[i], registers[i])
+			t.Fail()
+		}
+	}
+}
+
+func createSparseSketch(t *testing.T) *sketch {
+	options := DefaultSketchOptions()
+	options.Sparse = true
+
+	s, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*sketch)
+}
+
+func TestSparseSketch_MatchesDense(t *testing.T) {
+	rand.Seed(0)
+
+	sparse := createSparseSketch(t)
+	dense := createSketch()
+
+	for i := 0; i < 1_000; i++ {
+		h := rand.Uint64()
+
+		sparse.addHash(h)
+		dense.addHash(h)
+	}
+
+	if sparse.sparse == nil {
+		t.Fatalf("sparse sketch - expected sketch to still be sparse after 1,000 inserts")
+	}
+
+	if sparse.Estimate() != dense.Estimate() {
+		t.Logf("sparse sketch - expected estimate to match dense: %d, got: %d", dense.Estimate(), sparse.Estimate())
+		t.Fail()
+	}
+
+	registers := sparse.getRegisters()
+
+	for i, r := range dense.getRegisters() {
+		if registers[i] != r {
+			t.Fatalf("sparse sketch - register %d expected: %d, got: %d", i, r, registers[i])
+		}
+	}
+}
+
+func TestSparseSketch_ConvertsToDense(t *testing.T) {
+	rand.Seed(0)
+
+	s := createSparseSketch(t)
+
+	for i := 0; i < 10_000; i++ {
+		s.addHash(rand.Uint64())
+	}
+
+	if s.sparse != nil || s.registers.count() != s.registerCount() {
+		t.Fatalf("sparse sketch - expected sketch to have converted to dense after 10,000 inserts")
+	}
+
+	if !acceptableEstimate(10_000, s.Estimate()) {
+		t.Logf("sparse sketch - expected a cardinality +/-3%% of: %d, got: %d", 10_000, s.Estimate())
+		t.Fail()
+	}
+}
+
+func TestSparseSketch_Merge(t *testing.T) {
+	rand.Seed(0)
+
+	hashes := make([]uint64, 2_000)
+
+	for i := range hashes {
+		hashes[i] = rand.Uint64()
+	}
+
+	expected := createSketch()
+
+	for _, h := range hashes {
+		expected.addHash(h)
+	}
+
+	// Sparse/dense combinations of each half.
+	combinations := [][2]bool{{true, true}, {true, false}, {false, true}, {false, false}}
+
+	for _, sparse := range combinations {
+		sketches := [2]*sketch{createSketch(), createSketch()}
+
+		for i, isSparse := range sparse {
+			if isSparse {
+				sketches[i] = createSparseSketch(t)
+			}
+		}
+
+		for i, h := range hashes {
+			sketches[i%2].addHash(h)
+		}
+
+		_, err := sketches[0].Merge(sketches[1])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		registers := sketches[0].getRegisters()
+
+		for i, r := range expected.getRegisters() {
+			if registers[i] != r {
+				t.Fatalf("sparse merge (sparse: %v) - register %d expected: %d, got: %d", sparse, i, r, registers[i])
+			}
+		}
+	}
+}
+
+func TestSparseSketch_ProtoRoundTrip(t *testing.T) {
+	rand.Seed(0)
+
+	s := createSparseSketch(t)
+
+	for i := 0; i < 100; i++ {
+		s.Insert([]byte(genPseudoRandomStr()))
+	}
+
+	bs, err := s.ProtoSerialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s1, err := ProtoDeserialize(bs)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Estimate() != s1.Estimate() {
+		t.Logf("sparse proto - expected estimate after round trip: %d, got: %d", s.Estimate(), s1.Estimate())
+		t.Fail()
+	}
+}
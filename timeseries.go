@@ -0,0 +1,493 @@
+package hll
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// TimeSeriesLevel configures one level of a TimeSeries.
+type TimeSeriesLevel struct {
+	// Granularity is the width of each bucket, e.g. time.Minute.
+	Granularity time.Duration
+
+	// Retention is how long buckets are kept for, relative to the latest insert. It must be at least
+	// Granularity.
+	Retention time.Duration
+}
+
+// DefaultTimeSeriesLevels returns minute buckets kept for an hour, hour buckets kept for a day, and day buckets
+// kept for 30 days.
+func DefaultTimeSeriesLevels() []TimeSeriesLevel {
+	return []TimeSeriesLevel{
+		{Granularity: time.Minute, Retention: time.Hour},
+		{Granularity: time.Hour, Retention: 24 * time.Hour},
+		{Granularity: 24 * time.Hour, Retention: 30 * 24 * time.Hour},
+	}
+}
+
+// TimeSeries holds a Sketch per time bucket, at several levels of granularity (e.g. minutes, hours and days).
+// Every insert is counted in its bucket at each level, so coarser buckets are always the rollup of the finer
+// buckets they cover, and can be kept for longer. Ranges are estimated by merging the coarsest buckets that
+// cover them.
+//
+// TimeSeries are not safe for concurrent use.
+type TimeSeries struct {
+	levels []timeSeriesLevel
+	latest int64
+
+	config *sketch
+}
+
+type timeSeriesLevel struct {
+	TimeSeriesLevel
+
+	// buckets are keyed by their start (in unix nanoseconds).
+	buckets map[int64]*sketch
+}
+
+// NewTimeSeries returns a new TimeSeries with levels (ordered finest first), whose buckets are configured by
+// options (or DefaultSketchOptions() if options is nil). Each level's Granularity must be a multiple of the
+// previous level's, so buckets nest. An error is returned if levels or options are invalid.
+func NewTimeSeries(levels []TimeSeriesLevel, options *SketchOptions) (*TimeSeries, error) {
+	if err := validateTimeSeriesLevels(levels); err != nil {
+		return nil, err
+	}
+
+	config, err := newConfigSketch(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newTimeSeries(levels, config), nil
+}
+
+func validateTimeSeriesLevels(levels []TimeSeriesLevel) error {
+	if len(levels) <= 0 {
+		return fmt.Errorf("invalid levels: time series requires at least one level")
+	}
+
+	for i, level := range levels {
+		if level.Granularity <= 0 || level.Retention < level.Granularity {
+			return fmt.Errorf("invalid level %d: granularity must be positive, and no larger than retention", i)
+		}
+
+		if i > 0 && (level.Granularity <= levels[i-1].Granularity || level.Granularity%levels[i-1].Granularity != 0) {
+			return fmt.Errorf("invalid level %d: granularity must be a larger multiple of the previous level's", i)
+		}
+	}
+
+	return nil
+}
+
+func newTimeSeries(levels []TimeSeriesLevel, config *sketch) *TimeSeries {
+	ts := &TimeSeries{
+		levels: make([]timeSeriesLevel, len(levels)),
+		config: config,
+	}
+
+	for i, level := range levels {
+		ts.levels[i] = timeSeriesLevel{
+			TimeSeriesLevel: level,
+			buckets:         map[int64]*sketch{},
+		}
+	}
+
+	return ts
+}
+
+// InsertAt inserts element into the bucket covering t at each level. Levels that no longer retain t's bucket
+// (relative to the latest insert) ignore it.
+func (ts *TimeSeries) InsertAt(element []byte, t time.Time) {
+	ts.insertHashAt(ts.config.hash(element), t.UnixNano())
+}
+
+func (ts *TimeSeries) insertHashAt(h uint64, at int64) {
+	if at > ts.latest {
+		ts.advance(at)
+	}
+
+	for i := range ts.levels {
+		level := &ts.levels[i]
+		start := floorTo(at, level.Granularity)
+
+		if start < level.oldest(ts.latest) {
+			continue
+		}
+
+		bucket, exists := level.buckets[start]
+
+		if !exists {
+			bucket = ts.config.emptyCopy()
+			level.buckets[start] = bucket
+		}
+
+		bucket.addHash(h)
+	}
+}
+
+// advance moves latest up to at, dropping any buckets that are no longer retained.
+func (ts *TimeSeries) advance(at int64) {
+	finest := ts.levels[0].Granularity
+	crossed := floorTo(at, finest) != floorTo(ts.latest, finest)
+
+	ts.latest = at
+
+	// (Buckets can only expire once latest moves into a new bucket at the finest level).
+	if !crossed {
+		return
+	}
+
+	for i := range ts.levels {
+		level := &ts.levels[i]
+		oldest := level.oldest(ts.latest)
+
+		for start := range level.buckets {
+			if start < oldest {
+				delete(level.buckets, start)
+			}
+		}
+	}
+}
+
+// oldest returns the start of the oldest bucket retained by level, given the latest insert.
+func (level *timeSeriesLevel) oldest(latest int64) int64 {
+	return floorTo(latest-int64(level.Retention), level.Granularity)
+}
+
+// EstimateRange returns the estimated cardinality of elements inserted in [from, to), by merging the coarsest
+// retained buckets that cover the range. from and to are rounded out to the finest granularity. An error is
+// returned if any of the range is no longer retained at any level.
+func (ts *TimeSeries) EstimateRange(from, to time.Time) (uint64, error) {
+	s := ts.config.emptyCopy()
+
+	finest := ts.levels[0].Granularity
+	start, end := floorTo(from.UnixNano(), finest), ceilTo(to.UnixNano(), finest)
+
+	err := ts.cover(len(ts.levels)-1, start, end, func(bucket *sketch) error {
+		_, err := s.Merge(bucket)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Estimate(), nil
+}
+
+// cover calls fn with each bucket (at level or finer) needed to cover [from, to), using the coarsest buckets
+// possible. from and to must be aligned to the finest granularity.
+func (ts *TimeSeries) cover(level int, from, to int64, fn func(bucket *sketch) error) error {
+	if from >= to {
+		return nil
+	}
+
+	if level < 0 {
+		return fmt.Errorf("range [%v, %v) is no longer retained", time.Unix(0, from).UTC(), time.Unix(0, to).UTC())
+	}
+
+	l := &ts.levels[level]
+
+	start, end := ceilTo(from, l.Granularity), floorTo(to, l.Granularity)
+
+	if oldest := l.oldest(ts.latest); start < oldest {
+		start = oldest
+	}
+
+	if start >= end {
+		return ts.cover(level-1, from, to, fn)
+	}
+
+	for bucketStart := start; bucketStart < end; bucketStart += int64(l.Granularity) {
+		// (Missing buckets had nothing inserted).
+		if bucket, exists := l.buckets[bucketStart]; exists {
+			if err := fn(bucket); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ts.cover(level-1, from, start, fn); err != nil {
+		return err
+	}
+
+	return ts.cover(level-1, end, to, fn)
+}
+
+// floorTo rounds t down to a multiple of d.
+func floorTo(t int64, d time.Duration) int64 {
+	r := t % int64(d)
+
+	if r < 0 {
+		r += int64(d)
+	}
+
+	return t - r
+}
+
+// ceilTo rounds t up to a multiple of d.
+func ceilTo(t int64, d time.Duration) int64 {
+	floor := floorTo(t, d)
+
+	if floor == t {
+		return t
+	}
+
+	return floor + int64(d)
+}
+
+// TimeSeries are serialized as the following (protobuf compatible) message, with each bucket (and the empty
+// sketch carrying the configuration of new buckets) in the usual proto sketch encoding (see ProtoSerialize):
+//
+//	message TimeSeries {
+//	  int64 latest = 1;
+//	  bytes config = 2;
+//	  repeated Level levels = 3;
+//	}
+//
+//	message Level {
+//	  int64 granularity = 1;
+//	  int64 retention = 2;
+//	  repeated Bucket buckets = 3;
+//	}
+//
+//	message Bucket {
+//	  int64 start = 1;
+//	  bytes sketch = 2;
+//	}
+const (
+	timeSeriesLatestField = 1
+	timeSeriesConfigField = 2
+	timeSeriesLevelsField = 3
+
+	levelGranularityField = 1
+	levelRetentionField   = 2
+	levelBucketsField     = 3
+
+	bucketStartField  = 1
+	bucketSketchField = 2
+)
+
+// ProtoSerialize returns []byte representing this TimeSeries. (Like Sketches, this does not include custom
+// biases, estimators or register encodings).
+func (ts *TimeSeries) ProtoSerialize() ([]byte, error) {
+	config, err := ts.config.emptyCopy().ProtoSerialize()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bs []byte
+
+	bs = protowire.AppendTag(bs, timeSeriesLatestField, protowire.VarintType)
+	bs = protowire.AppendVarint(bs, uint64(ts.latest))
+	bs = protowire.AppendTag(bs, timeSeriesConfigField, protowire.BytesType)
+	bs = protowire.AppendBytes(bs, config)
+
+	for _, level := range ts.levels {
+		var levelBs []byte
+
+		levelBs = protowire.AppendTag(levelBs, levelGranularityField, protowire.VarintType)
+		levelBs = protowire.AppendVarint(levelBs, uint64(level.Granularity))
+		levelBs = protowire.AppendTag(levelBs, levelRetentionField, protowire.VarintType)
+		levelBs = protowire.AppendVarint(levelBs, uint64(level.Retention))
+
+		// (Sorted, so the same TimeSeries always serializes the same way).
+		starts := make([]int64, 0, len(level.buckets))
+
+		for start := range level.buckets {
+			starts = append(starts, start)
+		}
+
+		sort.Slice(starts, func(i, j int) bool {
+			return starts[i] < starts[j]
+		})
+
+		for _, start := range starts {
+			sketchBs, err := level.buckets[start].ProtoSerialize()
+
+			if err != nil {
+				return nil, err
+			}
+
+			var bucketBs []byte
+
+			bucketBs = protowire.AppendTag(bucketBs, bucketStartField, protowire.VarintType)
+			bucketBs = protowire.AppendVarint(bucketBs, uint64(start))
+			bucketBs = protowire.AppendTag(bucketBs, bucketSketchField, protowire.BytesType)
+			bucketBs = protowire.AppendBytes(bucketBs, sketchBs)
+
+			levelBs = protowire.AppendTag(levelBs, levelBucketsField, protowire.BytesType)
+			levelBs = protowire.AppendBytes(levelBs, bucketBs)
+		}
+
+		bs = protowire.AppendTag(bs, timeSeriesLevelsField, protowire.BytesType)
+		bs = protowire.AppendBytes(bs, levelBs)
+	}
+
+	return bs, nil
+}
+
+// ProtoDeserializeTimeSeries returns the TimeSeries represented by protoBs (from TimeSeries.ProtoSerialize).
+// An error is returned if protoBs is malformed, or any of its sketches can't be deserialized (including if
+// they're seeded, see ProtoDeserializeTimeSeriesWithSeed).
+func ProtoDeserializeTimeSeries(protoBs []byte) (*TimeSeries, error) {
+	return ProtoDeserializeTimeSeriesWithSeed(protoBs, 0)
+}
+
+// ProtoDeserializeTimeSeriesWithSeed returns the TimeSeries represented by protoBs, as
+// ProtoDeserializeTimeSeries, which was created with seed (see SketchOptions.Seed). An error wrapping
+// ErrorMismatchedSeed is returned if it wasn't.
+func ProtoDeserializeTimeSeriesWithSeed(protoBs []byte, seed uint64) (*TimeSeries, error) {
+	var latest int64
+	var config *sketch
+	var levels []TimeSeriesLevel
+	var buckets []map[int64]*sketch
+
+	err := forEachProtoField(protoBs, func(num protowire.Number, v uint64, bs []byte) error {
+		switch num {
+		case timeSeriesLatestField:
+			latest = int64(v)
+		case timeSeriesConfigField:
+			s, err := ProtoDeserializeWithSeed(bs, seed)
+
+			if err != nil {
+				return err
+			}
+
+			config = s.(*sketch).withoutRegisters()
+		case timeSeriesLevelsField:
+			level, levelBuckets, err := protoDeserializeLevel(bs, seed)
+
+			if err != nil {
+				return err
+			}
+
+			levels = append(levels, level)
+			buckets = append(buckets, levelBuckets)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed time series: %w", err)
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("malformed time series: missing config")
+	}
+
+	if err := validateTimeSeriesLevels(levels); err != nil {
+		return nil, fmt.Errorf("malformed time series: %w", err)
+	}
+
+	ts := newTimeSeries(levels, config)
+	ts.latest = latest
+
+	for i, levelBuckets := range buckets {
+		for start, bucket := range levelBuckets {
+			if err := checkMergeable(config, bucket); err != nil {
+				return nil, fmt.Errorf("malformed time series: bucket %v at level %d: %w", time.Unix(0, start).UTC(), i, err)
+			}
+		}
+
+		ts.levels[i].buckets = levelBuckets
+	}
+
+	return ts, nil
+}
+
+func protoDeserializeLevel(protoBs []byte, seed uint64) (TimeSeriesLevel, map[int64]*sketch, error) {
+	var level TimeSeriesLevel
+	buckets := map[int64]*sketch{}
+
+	err := forEachProtoField(protoBs, func(num protowire.Number, v uint64, bs []byte) error {
+		switch num {
+		case levelGranularityField:
+			level.Granularity = time.Duration(v)
+		case levelRetentionField:
+			level.Retention = time.Duration(v)
+		case levelBucketsField:
+			var start int64
+			var bucket *sketch
+
+			err := forEachProtoField(bs, func(num protowire.Number, v uint64, bs []byte) error {
+				switch num {
+				case bucketStartField:
+					start = int64(v)
+				case bucketSketchField:
+					s, err := ProtoDeserializeWithSeed(bs, seed)
+
+					if err != nil {
+						return err
+					}
+
+					bucket = s.(*sketch)
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+
+			if bucket == nil {
+				return fmt.Errorf("bucket missing sketch")
+			}
+
+			buckets[start] = bucket
+		}
+
+		return nil
+	})
+
+	return level, buckets, err
+}
+
+// forEachProtoField calls fn with each (varint or bytes) field in protoBs. Fields of any other type are
+// skipped.
+func forEachProtoField(protoBs []byte, fn func(num protowire.Number, v uint64, bs []byte) error) error {
+	for len(protoBs) > 0 {
+		num, typ, n := protowire.ConsumeTag(protoBs)
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		protoBs = protoBs[n:]
+
+		var v uint64
+		var bs []byte
+
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(protoBs)
+		case protowire.BytesType:
+			bs, n = protowire.ConsumeBytes(protoBs)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, protoBs)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		protoBs = protoBs[n:]
+
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+
+		if err := fn(num, v, bs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,53 +1,297 @@
 package hll
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
 
 // Rollup merges sketches into a single (new) Sketch that is slightly more efficient than
-// successively merging each into a common base, one at a time.
+// successively merging each into a common base, one at a time. As with Merge, an error wrapping
+// ErrorMismatchedVersion, ErrorMalformedPrecision, ErrorMismatchedHasher or ErrorMismatchedSeed is returned if
+// any sketch doesn't match the first.
 func Rollup(sketches []Sketch) (Sketch, error) {
-	if len(sketches) <= 0 || sketches == nil {
-		return nil, fmt.Errorf("rollup requires a list of sketches")
+	if err := validateRollup(sketches); err != nil {
+		return nil, err
 	}
 
-	// Validate version and len(registers).
-	firstVersion := sketches[0].getVersion()
-	firstLen := len(sketches[0].getRegisters())
+	base := newRollupBase(sketches[0])
 
-	for i := 1; i < len(sketches); i++ {
-		if sketches[i].getVersion() != firstVersion {
-			return nil, fmt.Errorf("rollup requires a list of sketches with the same version")
-		}
+	// (base is Encoding8Bit, so these are its underlying registers, not a copy).
+	baseRegisters := base.getRegisters()
 
-		if len(sketches[i].getRegisters()) != firstLen {
-			return nil, fmt.Errorf("rollup requires a list of sketches with the same precision (len of registers)")
+	// Take the max of each register, a word at a time, across each dense sketch. (Sparse sketches are cheaper to
+	// write straight into base).
+	for _, sk := range sketches {
+		if sp := sparseOf(sk); sp != nil {
+			sp.toDense(base.registers)
+			continue
 		}
+
+		maxBytes(baseRegisters, sk.getRegisters())
 	}
 
-	base := createSketch()
+	return base, nil
+}
 
-	if base.version != firstVersion {
-		base.version = firstVersion
+// RollupParallel merges sketches into a single (new) Sketch, as Rollup, but splits the work across workers
+// goroutines (or GOMAXPROCS, if workers <= 0). Each worker merges a share of sketches into its own registers,
+// then the workers' registers are combined, with each worker taking a range of registers.
+func RollupParallel(sketches []Sketch, workers int) (Sketch, error) {
+	if err := validateRollup(sketches); err != nil {
+		return nil, err
+	}
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers > len(sketches) {
+		workers = len(sketches)
+	}
+
+	// Reading sparse registers flushes any buffered inserts, so do that up front rather than concurrently.
+	for _, sk := range sketches {
+		if sp := sparseOf(sk); sp != nil {
+			sp.flush()
+		}
 	}
 
-	// Pull the registers for sketches.
-	registers := make([][]uint8, len(sketches))
+	base := newRollupBase(sketches[0])
 
-	for i, sk := range sketches {
-		registers[i] = sk.getRegisters()
+	// Each worker's registers, with the first worker using base's.
+	partials := make([]byteRegisters, workers)
+	partials[0] = base.registers.(byteRegisters)
+
+	for w := 1; w < workers; w++ {
+		partials[w] = make(byteRegisters, len(partials[0]))
 	}
 
-	// For each register, take the highest entry for this i across each other sketch.
-	for i := 0; i < len(base.registers); i++ {
-		max := uint8(0)
+	var wg sync.WaitGroup
+
+	forEachWorker := func(n int, fn func(from, to int, partial byteRegisters)) {
+		chunk := (n + workers - 1) / workers
 
-		for _, reg := range registers {
-			if max < reg[i] {
-				max = reg[i]
+		for w := 0; w < workers; w++ {
+			from, to := w*chunk, (w+1)*chunk
+
+			if from > n {
+				from = n
 			}
+
+			if to > n {
+				to = n
+			}
+
+			wg.Add(1)
+
+			go func(from, to int, partial byteRegisters) {
+				defer wg.Done()
+				fn(from, to, partial)
+			}(from, to, partials[w])
 		}
 
-		base.registers[i] = max
+		wg.Wait()
 	}
 
+	// Merge each share of sketches into its worker's registers...
+	forEachWorker(len(sketches), func(from, to int, partial byteRegisters) {
+		for _, sk := range sketches[from:to] {
+			if sp := sparseOf(sk); sp != nil {
+				sp.toDense(partial)
+				continue
+			}
+
+			mergeBytes(partial, sk.getRegisters())
+		}
+	})
+
+	// ...then each range of registers across workers into base.
+	forEachWorker(len(partials[0]), func(from, to int, _ byteRegisters) {
+		for _, partial := range partials[1:] {
+			mergeBytes(partials[0][from:to], partial[from:to])
+		}
+	})
+
 	return base, nil
 }
+
+// validateRollup returns an error if sketches can't be rolled up, including the index of the first sketch
+// that can't be. Sketches must have the same version, precision, hasher and seed, and dense sketches must
+// have 2^precision registers.
+func validateRollup(sketches []Sketch) error {
+	if len(sketches) <= 0 || sketches == nil {
+		return fmt.Errorf("rollup requires a list of sketches")
+	}
+
+	for i := 0; i < len(sketches); i++ {
+		if err := validateRollupSketch(sketches[0], sketches[i], i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRollupSketch returns an error if sk (at index i) can't be rolled up with first, wrapping the same errors
+// as Merge (e.g. ErrorMalformedPrecision).
+func validateRollupSketch(first, sk Sketch, i int) error {
+	if sk == nil {
+		return fmt.Errorf("rollup requires non-nil sketches (sketch %d is nil)", i)
+	}
+
+	if sk.getVersion() != first.getVersion() {
+		return fmt.Errorf("rollup requires a list of sketches with the same version (sketch %d has version: %s, expected: %s): %w", i, sk.getVersion(), first.getVersion(), ErrorMismatchedVersion)
+	}
+
+	if sk.getPrecision() != first.getPrecision() {
+		return fmt.Errorf("rollup requires a list of sketches with the same precision (sketch %d has precision: %d, expected: %d): %w", i, sk.getPrecision(), first.getPrecision(), ErrorMalformedPrecision)
+	}
+
+	if sk.getHasher().ID() != first.getHasher().ID() {
+		return fmt.Errorf("rollup requires a list of sketches with the same hasher (sketch %d has hasher: %s, expected: %s): %w", i, sk.getHasher().ID(), first.getHasher().ID(), ErrorMismatchedHasher)
+	}
+
+	if sk.getSeedFingerprint() != first.getSeedFingerprint() {
+		return fmt.Errorf("rollup requires a list of sketches with the same seed (sketch %d differs): %w", i, ErrorMismatchedSeed)
+	}
+
+	// (Only byte registers can be the wrong length, e.g. when malformed. Reading the registers of anything else
+	// could mean a copy or snapshot, which would be thrown away).
+	if s, ok := sk.(*sketch); ok {
+		if registers, ok := s.registers.(byteRegisters); ok && len(registers) != 1<<first.getPrecision() {
+			return fmt.Errorf("rollup requires a list of sketches with the same precision (sketch %d has %d registers, expected: %d): %w", i, len(registers), 1<<first.getPrecision(), ErrorMalformedPrecision)
+		}
+	}
+
+	return nil
+}
+
+// newRollupBase returns an empty (Encoding8Bit) sketch to roll sketches up into, configured as first.
+func newRollupBase(first Sketch) *sketch {
+	base := createSketchWithPrecision(first.getPrecision())
+
+	base.version = first.getVersion()
+	base.hasher = first.getHasher()
+	base.seed = first.getSeed()
+	base.seedFingerprint = first.getSeedFingerprint()
+
+	return base
+}
+
+// RollupAccumulator rolls up sketches one at a time, as they arrive, so they don't all need to be held in
+// memory at once (as with Rollup). Each added Sketch is validated against the first, then folded into a
+// running maximum of each register. The zero value is an empty RollupAccumulator, ready to use.
+type RollupAccumulator struct {
+	// base is configured as the first Sketch added (so is what the rest are validated against).
+	base  *sketch
+	added int
+}
+
+// Add validates sk against the first Sketch added, then folds it into the rollup. sk is not modified.
+func (a *RollupAccumulator) Add(sk Sketch) error {
+	if a.base == nil {
+		if err := validateRollupSketch(sk, sk, a.added); err != nil {
+			return err
+		}
+
+		a.base = newRollupBase(sk)
+	} else if err := validateRollupSketch(a.base, sk, a.added); err != nil {
+		return err
+	}
+
+	if sp := sparseOf(sk); sp != nil {
+		sp.toDense(a.base.registers)
+	} else {
+		mergeBytes(a.base.registers, sk.getRegisters())
+	}
+
+	a.added += 1
+
+	return nil
+}
+
+// Result returns a (new) Sketch holding the rollup of every Sketch added so far, or nil if none have been.
+func (a *RollupAccumulator) Result() Sketch {
+	if a.base == nil {
+		return nil
+	}
+
+	return a.base.Clone()
+}
+
+// RollupFrom rolls up every Sketch returned by next until it returns io.EOF, without holding them all in
+// memory at once. An error is returned if next returns any other error, or no Sketches (see Rollup).
+func RollupFrom(next func() (Sketch, error)) (Sketch, error) {
+	if next == nil {
+		return nil, fmt.Errorf("rollup requires a next fn")
+	}
+
+	var a RollupAccumulator
+
+	for {
+		sk, err := next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = a.Add(sk); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.base == nil {
+		return nil, fmt.Errorf("rollup requires a list of sketches")
+	}
+
+	return a.base, nil
+}
+
+// RotateSeed returns a new Sketch configured as sk, but using seed, built by inserting every element
+// returned by next until it returns io.EOF. (Registers can't be re-hashed, so the raw elements are needed).
+// An error is returned if seed is 0, or next returns any other error.
+func RotateSeed(sk Sketch, seed uint64, next func() ([]byte, error)) (Sketch, error) {
+	if seed == 0 {
+		return nil, fmt.Errorf("rotate seed requires a non-zero seed")
+	}
+
+	if next == nil {
+		return nil, fmt.Errorf("rotate seed requires a next fn")
+	}
+
+	var rotated *sketch
+
+	if s, ok := sk.(*sketch); ok {
+		rotated = s.emptyCopy()
+	} else {
+		rotated = createSketchWithPrecision(sk.getPrecision())
+		rotated.hasher = sk.getHasher()
+		rotated.version = sk.getVersion()
+	}
+
+	if _, ok := rotated.hasher.(SeededHasher); !ok {
+		return nil, fmt.Errorf("rotate seed requires a sketch with a seeded hasher, %s does not support seeds", rotated.hasher.ID())
+	}
+
+	rotated.setSeed(seed)
+
+	for {
+		element, err := next()
+
+		if err == io.EOF {
+			return rotated, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		rotated.Insert(element)
+	}
+}
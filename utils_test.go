@@ -1,6 +1,12 @@
 package hll
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
 
 func TestRollup_Nil(t *testing.T) {
 	_, err := Rollup(nil)
@@ -29,8 +35,8 @@ func TestRollup_DiffVersion(t *testing.T) {
 
 	_, err := Rollup([]Sketch{s0, s1})
 
-	if err == nil {
-		t.Logf("rollup - expected rollup to error with different versions, but did not")
+	if !errors.Is(err, ErrorMismatchedVersion) {
+		t.Logf("rollup - expected rollup to error with different versions: %v, got: %v", ErrorMismatchedVersion, err)
 		t.Fail()
 	}
 }
@@ -39,22 +45,51 @@ func TestRollup_DiffPrecision(t *testing.T) {
 	s0 := createSketch()
 
 	s1 := createSketch()
-	s1.registers = make([]uint8, 1)
+	s1.registers = make(byteRegisters, 1)
 
 	_, err := Rollup([]Sketch{s0, s1})
 
-	if err == nil {
-		t.Logf("rollup - expected rollup to error with different precision/malformed registers, but did not")
+	if !errors.Is(err, ErrorMalformedPrecision) {
+		t.Logf("rollup - expected rollup to error with different precision/malformed registers: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
+
+func TestRollup_DiffPrecisionSameLen(t *testing.T) {
+	s0 := createSketch()
+	s1 := createSketchWithPrecision(DefaultPrecision - 1)
+	s1.registers = make(byteRegisters, s0.registers.count())
+
+	_, err := Rollup([]Sketch{s0, s1})
+
+	if !errors.Is(err, ErrorMalformedPrecision) {
+		t.Logf("rollup - expected rollup to error with different precision: %v, got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
+
+func TestRollup_Precision(t *testing.T) {
+	s0 := createSketchWithPrecision(10)
+	s1 := createSketchWithPrecision(10)
+
+	res, err := Rollup([]Sketch{s0, s1})
+
+	if err != nil {
+		t.Fatalf("rollup - unexpected error for valid rollup: %v", err)
+	}
+
+	if res.getPrecision() != 10 || len(res.getRegisters()) != 1<<10 {
+		t.Logf("rollup - expected rollup to keep precision: %d, got: %d", 10, res.getPrecision())
 		t.Fail()
 	}
 }
 
 func TestRollup(t *testing.T) {
 	s0 := createSketch()
-	s0.registers[0] = 1
+	s0.registers.set(0, 1)
 
 	s1 := createSketch()
-	s1.registers[1] = 1
+	s1.registers.set(1, 1)
 
 	res, err := Rollup([]Sketch{s0, s1})
 
@@ -70,16 +105,81 @@ func TestRollup(t *testing.T) {
 	}
 }
 
+func TestRollup_Sparse(t *testing.T) {
+	s0 := createSparseSketch(t)
+	s0.addHash(0)
+
+	s1 := createSketch()
+	s1.registers.set(1, 1)
+
+	res, err := Rollup([]Sketch{s0, s1})
+
+	if err != nil {
+		t.Fatalf("rollup - unexpected error for valid rollup (sparse): %v", err)
+	}
+
+	resRegisters := res.getRegisters()
+	register, zeros := getRegisterAndLeadingZeros(0, DefaultPrecision)
+
+	if resRegisters[register] != zeros+1 || resRegisters[1] != 1 {
+		t.Logf("rollup - expected rollup to contain both sparse and dense registers, but did not")
+		t.Fail()
+	}
+}
+
+// countingSketch counts calls to getRegisters, which can be expensive for other Sketches (e.g. a snapshot).
+type countingSketch struct {
+	Sketch
+	calls int
+}
+
+func (c *countingSketch) getRegisters() []uint8 {
+	c.calls += 1
+	return c.Sketch.getRegisters()
+}
+
+func TestRollup_RegistersReadOnce(t *testing.T) {
+	options := DefaultSketchOptions()
+	options.Encoding = Encoding6Bit
+
+	packed, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &countingSketch{Sketch: packed}
+	concurrent := &countingSketch{Sketch: createConcurrentSketch(t)}
+
+	rollups := map[string]func([]Sketch) (Sketch, error){
+		"rollup":          Rollup,
+		"rollup parallel": func(sketches []Sketch) (Sketch, error) { return RollupParallel(sketches, 2) },
+	}
+
+	for name, rollup := range rollups {
+		counting.calls, concurrent.calls = 0, 0
+
+		if _, err := rollup([]Sketch{createSketch(), counting, concurrent}); err != nil {
+			t.Fatal(err)
+		}
+
+		if counting.calls != 1 || concurrent.calls != 1 {
+			t.Logf("%s - expected registers to be read once (during the merge), got: %d (concurrent: %d)", name, counting.calls, concurrent.calls)
+			t.Fail()
+		}
+	}
+}
+
 func TestRollup_DiffVersionToStandard(t *testing.T) {
 	expectedVersion := "TEST"
 
 	s0 := createSketch()
 	s0.version = expectedVersion
-	s0.registers[0] = 1
+	s0.registers.set(0, 1)
 
 	s1 := createSketch()
 	s1.version = expectedVersion
-	s1.registers[1] = 1
+	s1.registers.set(1, 1)
 
 	res, err := Rollup([]Sketch{s0, s1})
 
@@ -95,3 +195,395 @@ func TestRollup_DiffVersionToStandard(t *testing.T) {
 	}
 
 }
+
+func TestRollup_Mismatched(t *testing.T) {
+	version := createSketch()
+	version.version = "TEST"
+
+	tests := []struct {
+		name     string
+		sk       Sketch
+		expected error
+	}{
+		{"version", version, ErrorMismatchedVersion},
+		{"precision", createSketchWithPrecision(DefaultPrecision - 1), ErrorMalformedPrecision},
+		{"hasher", createHasherSketch(t, Murmur3Hasher{}), ErrorMismatchedHasher},
+		{"seed", createSeededSketch(t, 1), ErrorMismatchedSeed},
+	}
+
+	for _, test := range tests {
+		sketches := []Sketch{createSketch(), test.sk}
+
+		_, err := Rollup(sketches)
+
+		if !errors.Is(err, test.expected) {
+			t.Logf("rollup (%s) - expected error: %v, got: %v", test.name, test.expected, err)
+			t.Fail()
+		}
+
+		_, err = RollupParallel(sketches, 2)
+
+		if !errors.Is(err, test.expected) {
+			t.Logf("rollup parallel (%s) - expected error: %v, got: %v", test.name, test.expected, err)
+			t.Fail()
+		}
+
+		var a RollupAccumulator
+
+		if err := a.Add(sketches[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.Add(sketches[1]); !errors.Is(err, test.expected) {
+			t.Logf("rollup accumulator (%s) - expected error: %v, got: %v", test.name, test.expected, err)
+			t.Fail()
+		}
+	}
+}
+
+func elementsFn(elements [][]byte) func() ([]byte, error) {
+	i := 0
+
+	return func() ([]byte, error) {
+		if i >= len(elements) {
+			return nil, io.EOF
+		}
+
+		i += 1
+		return elements[i-1], nil
+	}
+}
+
+func TestRotateSeed(t *testing.T) {
+	rand.Seed(0)
+
+	options := DefaultSketchOptions()
+	options.Seed = 1
+	options.Encoding = Encoding6Bit
+
+	s0, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elements := make([][]byte, 10_000)
+
+	for i := range elements {
+		elements[i] = []byte(genPseudoRandomStr())
+		s0.Insert(elements[i])
+	}
+
+	s1, err := RotateSeed(s0, 2, elementsFn(elements))
+
+	if err != nil {
+		t.Fatalf("rotate seed - unexpected error: %v", err)
+	}
+
+	if s1.getSeed() != 2 || s1.(*sketch).encoding != Encoding6Bit {
+		t.Logf("rotate seed - expected seed: %d (encoding: %v), got: %d (encoding: %v)", 2, Encoding6Bit, s1.getSeed(), s1.(*sketch).encoding)
+		t.Fail()
+	}
+
+	if !acceptableEstimate(10_000, s1.Estimate()) {
+		t.Logf("rotate seed - expected a cardinality +/-3%% of: %d, got: %d", 10_000, s1.Estimate())
+		t.Fail()
+	}
+}
+
+func TestRotateSeed_Invalid(t *testing.T) {
+	_, err := RotateSeed(createSketch(), 0, elementsFn(nil))
+
+	if err == nil {
+		t.Logf("rotate seed - expected a zero seed to error, but did not")
+		t.Fail()
+	}
+
+	options := DefaultSketchOptions()
+	options.Hasher = Murmur3Hasher{}
+
+	s, err := NewSketchWithOptions(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RotateSeed(s, 1, elementsFn(nil))
+
+	if err == nil {
+		t.Logf("rotate seed - expected an unseeded hasher to error, but did not")
+		t.Fail()
+	}
+}
+
+func createRollupSketches(t testing.TB, n int) []Sketch {
+	rand.Seed(0)
+
+	sketches := make([]Sketch, n)
+
+	for i := range sketches {
+		options := DefaultSketchOptions()
+		options.Sparse = i%3 == 1
+		options.Encoding = testEncodings[i%len(testEncodings)]
+
+		s, err := NewSketchWithOptions(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < 1_000; j++ {
+			s.InsertHash(rand.Uint64())
+		}
+
+		sketches[i] = s
+	}
+
+	return sketches
+}
+
+func TestRollupParallel(t *testing.T) {
+	sketches := createRollupSketches(t, 50)
+	sketches = append(sketches, createConcurrentSketch(t))
+
+	expected, err := Rollup(sketches)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{0, 1, 3, 8, 100} {
+		rolled, err := RollupParallel(sketches, workers)
+
+		if err != nil {
+			t.Fatalf("rollup parallel (workers: %d) - unexpected error for valid rollup: %v", workers, err)
+		}
+
+		registers := rolled.getRegisters()
+
+		for i, r := range expected.getRegisters() {
+			if registers[i] != r {
+				t.Fatalf("rollup parallel (workers: %d) - register %d expected: %d, got: %d", workers, i, r, registers[i])
+			}
+		}
+	}
+}
+
+func TestRollupParallel_MoreWorkersThanRegisters(t *testing.T) {
+	sketches := make([]Sketch, 40)
+
+	for i := range sketches {
+		sketches[i] = createSketchWithPrecision(MinPrecision)
+		sketches[i].InsertUint64(uint64(i))
+	}
+
+	rolled, err := RollupParallel(sketches, len(sketches))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Rollup(sketches)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rolled.Estimate() != expected.Estimate() {
+		t.Logf("rollup parallel - expected estimate: %d, got: %d", expected.Estimate(), rolled.Estimate())
+		t.Fail()
+	}
+}
+
+func TestRollupParallel_Invalid(t *testing.T) {
+	_, err := RollupParallel(nil, 4)
+
+	if err == nil {
+		t.Logf("rollup parallel - expected rollup to error with nil, but did not")
+		t.Fail()
+	}
+
+	sketches := []Sketch{createSketch(), createSketch(), createSketchWithPrecision(DefaultPrecision - 1)}
+
+	_, err = RollupParallel(sketches, 4)
+
+	if !errors.Is(err, ErrorMalformedPrecision) || !strings.Contains(err.Error(), "sketch 2") {
+		t.Logf("rollup parallel - expected rollup to error with: %v and the index of the different sketch (2), got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
+
+func BenchmarkRollup(b *testing.B) {
+	sketches := createRollupSketches(b, 1_000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := Rollup(sketches); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRollupParallel(b *testing.B) {
+	sketches := createRollupSketches(b, 1_000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := RollupParallel(sketches, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// FuzzRollup checks that Rollup, RollupParallel and Merge all match a scalar max of each register, with data
+// split into the registers of MinPrecision sketches.
+func FuzzRollup(f *testing.F) {
+	f.Add([]byte("0123456789abcdef"))
+	f.Add([]byte("0123456789abcdeffedcba9876543210\x00\x7f\x80\xff\x00\x7f\x80\xff\x01\x02\x03\x3d\x3e\x3f\x40\x41"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		n := 1 << MinPrecision
+
+		if len(data) < n {
+			return
+		}
+
+		var sketches []Sketch
+		expected := make([]uint8, n)
+
+		for i := 0; i+n <= len(data); i += n {
+			s := createSketchWithPrecision(MinPrecision)
+			copy(s.registers.(byteRegisters), data[i:i+n])
+
+			sketches = append(sketches, s)
+			maxBytesScalar(expected, data[i:i+n])
+		}
+
+		rolled, err := Rollup(sketches)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parallel, err := RollupParallel(sketches, 3)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		merged := createSketchWithPrecision(MinPrecision)
+
+		for _, s := range sketches {
+			if _, err := merged.Merge(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, sk := range []Sketch{rolled, parallel, merged} {
+			registers := sk.getRegisters()
+
+			for i := range expected {
+				if registers[i] != expected[i] {
+					t.Fatalf("rollup - register %d expected: %d, got: %d", i, expected[i], registers[i])
+				}
+			}
+		}
+	})
+}
+
+func TestRollupAccumulator(t *testing.T) {
+	sketches := createRollupSketches(t, 20)
+
+	expected, err := Rollup(sketches)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a RollupAccumulator
+
+	if a.Result() != nil {
+		t.Fatalf("rollup accumulator - expected an empty accumulator to have a nil result")
+	}
+
+	for _, sk := range sketches {
+		if err := a.Add(sk); err != nil {
+			t.Fatalf("rollup accumulator - unexpected error adding a valid sketch: %v", err)
+		}
+	}
+
+	if a.Result().Estimate() != expected.Estimate() {
+		t.Logf("rollup accumulator - expected estimate: %d, got: %d", expected.Estimate(), a.Result().Estimate())
+		t.Fail()
+	}
+
+	err = a.Add(createSketchWithPrecision(DefaultPrecision - 1))
+
+	if !errors.Is(err, ErrorMalformedPrecision) || !strings.Contains(err.Error(), "sketch 20") {
+		t.Logf("rollup accumulator - expected add to error with: %v and the index of the different sketch (20), got: %v", ErrorMalformedPrecision, err)
+		t.Fail()
+	}
+}
+
+func TestRollupFrom(t *testing.T) {
+	sketches := createRollupSketches(t, 20)
+
+	expected, err := Rollup(sketches)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+
+	rolled, err := RollupFrom(func() (Sketch, error) {
+		if i >= len(sketches) {
+			return nil, io.EOF
+		}
+
+		i += 1
+
+		return sketches[i-1], nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registers := rolled.getRegisters()
+
+	for i, r := range expected.getRegisters() {
+		if registers[i] != r {
+			t.Fatalf("rollup from - register %d expected: %d, got: %d", i, r, registers[i])
+		}
+	}
+}
+
+func TestRollupFrom_Invalid(t *testing.T) {
+	_, err := RollupFrom(nil)
+
+	if err == nil {
+		t.Logf("rollup from - expected nil next fn to error, but did not")
+		t.Fail()
+	}
+
+	_, err = RollupFrom(func() (Sketch, error) {
+		return nil, io.EOF
+	})
+
+	if err == nil {
+		t.Logf("rollup from - expected no sketches to error, but did not")
+		t.Fail()
+	}
+
+	_, err = RollupFrom(func() (Sketch, error) {
+		return nil, io.ErrUnexpectedEOF
+	})
+
+	if err != io.ErrUnexpectedEOF {
+		t.Logf("rollup from - expected error from next fn: %v, got: %v", io.ErrUnexpectedEOF, err)
+		t.Fail()
+	}
+}
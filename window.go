@@ -0,0 +1,149 @@
+package hll
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// WindowSketch is a sliding window HyperLogLog, which estimates the cardinality of elements inserted within a
+// recent window of time (e.g. unique visitors in the last 5 minutes), as in "Sliding HyperLogLog: Estimating
+// cardinality in a data stream over a sliding window" (Chabchoub & Hebrail).
+//
+// Rather than the largest rank, each register keeps a list of every rank that could still become its largest
+// as older inserts leave the window (its "future possible maxima"). These are ordered by time, with each rank
+// smaller than the one before it, so they stay short (logarithmic in the number of inserts).
+//
+// WindowSketches are not safe for concurrent use.
+type WindowSketch struct {
+	registers [][]windowEntry
+
+	// maxWindow is the largest window that can be estimated. Entries older than this are dropped.
+	maxWindow time.Duration
+	latest    int64
+
+	config *sketch
+}
+
+// windowEntry is a rank that was inserted into a register at (unix nanoseconds).
+type windowEntry struct {
+	at   int64
+	rank uint8
+}
+
+// NewWindowSketch returns a new WindowSketch that can estimate windows up to maxWindow, configured by options
+// (or DefaultSketchOptions() if options is nil). WindowSketches always use dense registers, so options.Sparse
+// and options.Encoding are ignored. An error is returned if maxWindow isn't positive, or options are invalid.
+func NewWindowSketch(maxWindow time.Duration, options *SketchOptions) (*WindowSketch, error) {
+	if maxWindow <= 0 {
+		return nil, fmt.Errorf("invalid window %v: must be positive", maxWindow)
+	}
+
+	if options != nil {
+		o := *options
+		o.Sparse = false
+		o.Encoding = Encoding8Bit
+
+		options = &o
+	}
+
+	config, err := newConfigSketch(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &WindowSketch{
+		registers: make([][]windowEntry, config.registerCount()),
+		maxWindow: maxWindow,
+		config:    config,
+	}, nil
+}
+
+// InsertAt inserts element into the WindowSketch, as seen at t. Inserts don't need to be in time order, but
+// any older than the max window (relative to the latest insert) are ignored.
+func (w *WindowSketch) InsertAt(element []byte, t time.Time) {
+	w.insertHashAt(w.config.hash(element), t.UnixNano())
+}
+
+func (w *WindowSketch) insertHashAt(h uint64, at int64) {
+	if at > w.latest {
+		w.latest = at
+	}
+
+	expired := w.latest - int64(w.maxWindow)
+
+	if at < expired {
+		return
+	}
+
+	register, zeros := getRegisterAndLeadingZeros(h, w.config.precision)
+	rank := zeros + 1
+
+	entries := w.registers[register]
+
+	// Keep entries that haven't expired, and either came after this insert or have a larger rank.
+	kept := entries[:0]
+	dominated := false
+
+	for _, e := range entries {
+		if e.at < expired || (e.at <= at && e.rank <= rank) {
+			continue
+		}
+
+		// An entry at least as recent with at least the same rank makes this insert redundant.
+		if e.at >= at && e.rank >= rank {
+			dominated = true
+		}
+
+		kept = append(kept, e)
+	}
+
+	if !dominated {
+		i := sort.Search(len(kept), func(i int) bool {
+			return kept[i].at > at
+		})
+
+		kept = append(kept, windowEntry{})
+		copy(kept[i+1:], kept[i:])
+		kept[i] = windowEntry{at: at, rank: rank}
+	}
+
+	w.registers[register] = kept
+}
+
+// EstimateWindow returns the estimated cardinality of elements inserted within d of the latest insert.
+// d is capped at the max window.
+func (w *WindowSketch) EstimateWindow(d time.Duration) uint64 {
+	return w.window(d, w.latest).Estimate()
+}
+
+// EstimateWindowAt returns the estimated cardinality of elements inserted within d before now (which should
+// be no earlier than the latest insert). d is capped at the max window.
+func (w *WindowSketch) EstimateWindowAt(d time.Duration, now time.Time) uint64 {
+	return w.window(d, now.UnixNano()).Estimate()
+}
+
+// window returns a sketch holding the largest rank of each register inserted in (now - d, now].
+func (w *WindowSketch) window(d time.Duration, now int64) *sketch {
+	if d > w.maxWindow {
+		d = w.maxWindow
+	}
+
+	from := now - int64(d)
+
+	s := w.config.emptyCopy()
+	registers := s.registers.(byteRegisters)
+
+	for i, entries := range w.registers {
+		// (Ranks decrease over time, so the first entry in the window has the largest).
+		for _, e := range entries {
+			if e.at > from && e.at <= now {
+				registers[i] = e.rank
+				break
+			}
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,136 @@
+package hll
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func createWindowSketch(t *testing.T, maxWindow time.Duration) *WindowSketch {
+	w, err := NewWindowSketch(maxWindow, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// runWindowSketch checks every window of w matches a sketch of just the hashes inserted in that window,
+// where hashes[i] was inserted at start + i seconds.
+func runWindowSketch(t *testing.T, w *WindowSketch, hashes []uint64, start time.Time) {
+	latest := start.Add(time.Duration(len(hashes)-1) * time.Second)
+
+	for _, d := range []time.Duration{time.Second, time.Minute, time.Hour, 2 * time.Hour} {
+		expected := createSketch()
+
+		for i, h := range hashes {
+			if start.Add(time.Duration(i) * time.Second).After(latest.Add(-d)) {
+				expected.addHash(h)
+			}
+		}
+
+		registers := w.window(d, latest.UnixNano()).getRegisters()
+
+		for i, r := range expected.getRegisters() {
+			if registers[i] != r {
+				t.Fatalf("window sketch (window: %v) - register %d expected: %d, got: %d", d, i, r, registers[i])
+			}
+		}
+
+		if w.EstimateWindow(d) != expected.Estimate() {
+			t.Logf("window sketch (window: %v) - expected estimate: %d, got: %d", d, expected.Estimate(), w.EstimateWindow(d))
+			t.Fail()
+		}
+	}
+}
+
+func TestWindowSketch(t *testing.T) {
+	rand.Seed(0)
+
+	start := time.Unix(1_600_000_000, 0)
+	hashes := make([]uint64, 2*60*60)
+	w := createWindowSketch(t, 2*time.Hour)
+
+	for i := range hashes {
+		hashes[i] = rand.Uint64()
+		w.insertHashAt(hashes[i], start.Add(time.Duration(i)*time.Second).UnixNano())
+	}
+
+	runWindowSketch(t, w, hashes, start)
+
+	// Nothing was inserted in the last minute, an hour later.
+	if estimate := w.EstimateWindowAt(time.Minute, start.Add(3*time.Hour)); estimate != 0 {
+		t.Logf("window sketch - expected an estimate for an empty window of: %d, got: %d", 0, estimate)
+		t.Fail()
+	}
+}
+
+func TestWindowSketch_OutOfOrder(t *testing.T) {
+	rand.Seed(0)
+
+	start := time.Unix(1_600_000_000, 0)
+	hashes := make([]uint64, 2*60*60)
+	w := createWindowSketch(t, 2*time.Hour)
+
+	for i := range hashes {
+		hashes[i] = rand.Uint64()
+	}
+
+	for _, i := range rand.Perm(len(hashes)) {
+		w.insertHashAt(hashes[i], start.Add(time.Duration(i)*time.Second).UnixNano())
+	}
+
+	runWindowSketch(t, w, hashes, start)
+}
+
+func TestWindowSketch_InsertAt(t *testing.T) {
+	w := createWindowSketch(t, time.Hour)
+	now := time.Now()
+
+	for i := 0; i < 10_000; i++ {
+		w.InsertAt([]byte(genPseudoRandomStr()), now.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	// The last 5 seconds hold 5,000 elements, anything larger all 10,000.
+	if !acceptableEstimate(5_000, w.EstimateWindow(5*time.Second)) {
+		t.Logf("window sketch - expected a cardinality +/-3%% of: %d, got: %d", 5_000, w.EstimateWindow(5*time.Second))
+		t.Fail()
+	}
+
+	if !acceptableEstimate(10_000, w.EstimateWindow(2*time.Hour)) {
+		t.Logf("window sketch - expected a cardinality +/-3%% of: %d, got: %d", 10_000, w.EstimateWindow(2*time.Hour))
+		t.Fail()
+	}
+}
+
+func TestWindowSketch_Expired(t *testing.T) {
+	w := createWindowSketch(t, time.Minute)
+	now := time.Now()
+
+	w.InsertAt([]byte("old"), now)
+	w.InsertAt([]byte("new"), now.Add(2*time.Minute))
+
+	// Too old to be kept.
+	w.InsertAt([]byte("older"), now.Add(-time.Minute))
+
+	if w.EstimateWindow(time.Hour) != 1 {
+		t.Logf("window sketch - expected only the latest insert within the max window, got estimate: %d", w.EstimateWindow(time.Hour))
+		t.Fail()
+	}
+}
+
+func TestNewWindowSketch_Invalid(t *testing.T) {
+	if _, err := NewWindowSketch(0, nil); err == nil {
+		t.Logf("new window sketch - expected a zero window to error, but did not")
+		t.Fail()
+	}
+
+	options := DefaultSketchOptions()
+	options.Precision = MaxPrecision + 1
+
+	if _, err := NewWindowSketch(time.Minute, options); err == nil {
+		t.Logf("new window sketch - expected invalid options to error, but did not")
+		t.Fail()
+	}
+}